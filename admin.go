@@ -0,0 +1,256 @@
+package main
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// ----------------------------------------------------------------------------
+// a d m i n   m o d e r a t i o n
+// ----------------------------------------------------------------------------
+
+// admin actions recorded in the audit log
+const (
+	adminView   = "view"
+	adminHide   = "hide"
+	adminUnhide = "unhide"
+	adminDelete = "delete"
+)
+
+// errNothingToDo is returned from admin transactions when the review is
+// already in the state the admin asked for
+var errNothingToDo = errors.New("review already in requested state")
+
+// ----------------------------------------------------------------------------
+
+func (a *App) adminGetReview(c *gin.Context) {
+
+	u := currentUser(c)
+
+	rId, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		a.Log.Info().Msgf("Not a uuid string: [%s]", err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Not a uuid string"})
+		return
+	}
+
+	var rv Review
+	res := a.DB.Unscoped().Where("review_id = ?", rId).Limit(1).Find(&rv)
+	if res.Error != nil {
+		a.Log.Info().Msgf("Error fetching review: [%s]", res.Error.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Something went bang"})
+		return
+	}
+	if res.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Review not found"})
+		return
+	}
+
+	reviews := []Review{rv}
+	var revisions []ReviewRevision
+	err = a.attachReplies(reviews)
+	if err == nil {
+		err = a.DB.Where("review_id = ?", rId).Order("version").Find(&revisions).Error
+	}
+	if err == nil {
+		err = recordAdminAction(a.DB, u, adminView, rId, "")
+	}
+	if err != nil {
+		a.Log.Info().Msgf("Error fetching review details: [%s]", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Something went bang"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"review": reviews[0], "revisions": revisions})
+}
+
+// ----------------------------------------------------------------------------
+
+func (a *App) adminHideReview(c *gin.Context) {
+
+	a.adminChangeReview(c, adminHide, "Hidden by admin", "Review is already hidden",
+		func(tx *gorm.DB, rv *Review, actor uuid.UUID, reason string) error {
+			if rv.Hidden {
+				return errNothingToDo
+			}
+			rv.Hidden = true
+			return tx.Model(rv).Updates(map[string]interface{}{
+				"hidden":        true,
+				"hidden_reason": reason,
+				"hidden_by":     actor,
+			}).Error
+		})
+}
+
+// ----------------------------------------------------------------------------
+
+func (a *App) adminUnhideReview(c *gin.Context) {
+
+	a.adminChangeReview(c, adminUnhide, "", "Review is not hidden",
+		func(tx *gorm.DB, rv *Review, actor uuid.UUID, reason string) error {
+			if !rv.Hidden {
+				return errNothingToDo
+			}
+			rv.Hidden = false
+			return tx.Model(rv).Updates(map[string]interface{}{
+				"hidden":        false,
+				"hidden_reason": "",
+				"hidden_by":     nil,
+			}).Error
+		})
+}
+
+// ----------------------------------------------------------------------------
+
+func (a *App) adminDeleteReview(c *gin.Context) {
+
+	a.adminChangeReview(c, adminDelete, "Deleted by admin", "Review is already deleted",
+		func(tx *gorm.DB, rv *Review, actor uuid.UUID, reason string) error {
+			if rv.DeletedAt.Valid {
+				return errNothingToDo
+			}
+			now := time.Now()
+			rv.DeletedAt = gorm.DeletedAt{Time: now, Valid: true}
+			return tx.Model(rv).Updates(map[string]interface{}{
+				"deleted_at":     now,
+				"deleted_reason": reason,
+				"deleted_by":     actor,
+			}).Error
+		})
+}
+
+// ----------------------------------------------------------------------------
+
+// adminChangeReview locks any review, deleted or not, and applies the change
+// to it. the seller's score totals and the audit log are updated in the same
+// transaction
+func (a *App) adminChangeReview(c *gin.Context, action, defaultReason, conflictMess string,
+	change func(tx *gorm.DB, rv *Review, actor uuid.UUID, reason string) error) {
+
+	u := currentUser(c)
+	actor, err := uuid.Parse(u.PublicId)
+	if err != nil {
+		a.Log.Info().Msgf("Admin public id is not a uuid: [%s]", err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Bad request"})
+		return
+	}
+
+	rId, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		a.Log.Info().Msgf("Not a uuid string: [%s]", err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Not a uuid string"})
+		return
+	}
+
+	var ri ReasonInput
+	if err = bindOptionalReason(c, &ri); err != nil {
+		a.Log.Info().Msgf("Input data does not match reason: [%s]", err.Error())
+		badInput(c, err)
+		return
+	}
+	reason := strings.TrimSpace(ri.Reason)
+	if reason == "" {
+		reason = defaultReason
+	}
+
+	err = a.DB.Transaction(func(tx *gorm.DB) error {
+		var rv Review
+		res := tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("review_id = ?", rId).
+			Limit(1).
+			Find(&rv)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errNoReview
+		}
+		counted := countsTowardsScores(&rv)
+		if err := change(tx.Unscoped(), &rv, actor, reason); err != nil {
+			return err
+		}
+		if err := updateSellerScores(tx, &rv, counted); err != nil {
+			return err
+		}
+		return recordAdminAction(tx, u, action, rId, reason)
+	})
+	if errors.Is(err, errNoReview) {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Review not found"})
+		return
+	}
+	if errors.Is(err, errNothingToDo) {
+		c.JSON(http.StatusConflict, gin.H{"message": conflictMess})
+		return
+	}
+	if err != nil {
+		a.Log.Info().Msgf("Error during admin [%s] of review [%s]: [%s]", action, rId.String(), err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Something went pop"})
+		return
+	}
+
+	a.Log.Info().Msgf("Admin [%s] did [%s] to review [%s]", u.PublicId, action, rId.String())
+	c.JSON(http.StatusOK, gin.H{"review_id": rId, "action": action})
+}
+
+// ----------------------------------------------------------------------------
+
+func (a *App) adminGetAudit(c *gin.Context) {
+
+	db := a.DB.Order("created desc").Limit(100)
+	if c.Query("review_id") != "" {
+		rId, err := uuid.Parse(c.Query("review_id"))
+		if err != nil {
+			a.Log.Info().Msgf("Not a uuid string: [%s]", err.Error())
+			c.JSON(http.StatusBadRequest, gin.H{"message": "Not a uuid string"})
+			return
+		}
+		db = db.Where("review_id = ?", rId)
+	}
+
+	var actions []AdminAction
+	if err := db.Find(&actions).Error; err != nil {
+		a.Log.Info().Msgf("Error fetching audit log: [%s]", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Something went bang"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"actions": actions})
+}
+
+// ----------------------------------------------------------------------------
+
+// adminGetStatus is /reviews/status with the token cache stats, which are
+// only for admins as they show how busy and how attacked we are
+func (a *App) adminGetStatus(c *gin.Context) {
+
+	resp := gin.H{"message": "System running...", "version": os.Getenv("VERSION")}
+	if a.TokenCache != nil {
+		resp["token_cache"] = a.TokenCache.stats()
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// ----------------------------------------------------------------------------
+
+func recordAdminAction(tx *gorm.DB, u user, action string, rId uuid.UUID, reason string) error {
+
+	actor, err := uuid.Parse(u.PublicId)
+	if err != nil {
+		return err
+	}
+	actionId, _ := uuid.NewRandom()
+	return tx.Create(&AdminAction{
+		ActionId: actionId,
+		Actor:    actor,
+		Action:   action,
+		ReviewId: rId,
+		Reason:   reason,
+	}).Error
+}
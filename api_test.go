@@ -2,7 +2,14 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -18,7 +25,9 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -74,6 +83,7 @@ var a *App
 func TestMain(m *testing.M) {
 	a = NewAppForTest()
 	code := m.Run()
+	a.Stop()
 	os.Exit(code)
 }
 
@@ -97,10 +107,47 @@ func checkResponseCode(t *testing.T, expected, actual int) bool {
 }
 
 func clearTable() {
-	res := a.DB.Where("1 = 1").Delete(&Review{})
+	res := a.DB.Unscoped().Where("1 = 1").Delete(&Review{})
 	if res.Error != nil {
 		a.Log.Fatal().Msg(res.Error.Error())
 	}
+	res = a.DB.Where("1 = 1").Delete(&SellerScore{})
+	if res.Error != nil {
+		a.Log.Fatal().Msg(res.Error.Error())
+	}
+	res = a.DB.Where("1 = 1").Delete(&ScoreCount{})
+	if res.Error != nil {
+		a.Log.Fatal().Msg(res.Error.Error())
+	}
+	res = a.DB.Where("1 = 1").Delete(&ReviewReply{})
+	if res.Error != nil {
+		a.Log.Fatal().Msg(res.Error.Error())
+	}
+	res = a.DB.Where("1 = 1").Delete(&ReviewRevision{})
+	if res.Error != nil {
+		a.Log.Fatal().Msg(res.Error.Error())
+	}
+	res = a.DB.Where("1 = 1").Delete(&AdminAction{})
+	if res.Error != nil {
+		a.Log.Fatal().Msg(res.Error.Error())
+	}
+	res = a.DB.Where("1 = 1").Delete(&ApiKey{})
+	if res.Error != nil {
+		a.Log.Fatal().Msg(res.Error.Error())
+	}
+	// tests reuse the same token for different users
+	if a.TokenCache != nil {
+		a.TokenCache.purge()
+	}
+	if a.RateLimiter != nil {
+		_ = a.RateLimiter.store.Reset()
+	}
+}
+
+func getSellerScore(id string) SellerScore {
+	var ss SellerScore
+	a.DB.Where("seller = ?", id).Limit(1).Find(&ss)
+	return ss
 }
 
 func getCountForUUIDKey(key string, id uuid.UUID) int64 {
@@ -115,6 +162,42 @@ func getTotalRecordsInTable() int64 {
 	return tc
 }
 
+// signHS256 and signRS256 make access tokens for testing local verification
+func signHS256(secret string, claims map[string]interface{}) string {
+	hdr, _ := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT"})
+	body, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(hdr) + "." + base64.RawURLEncoding.EncodeToString(body)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func signRS256(key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	hdr, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": kid})
+	body, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(hdr) + "." + base64.RawURLEncoding.EncodeToString(body)
+	sum := sha256.Sum256([]byte(signed))
+	sig, _ := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+const testIssuer = "authy"
+const testAudience = "reviews"
+
+func tokenClaims(publicId string, expires time.Time) map[string]interface{} {
+	return map[string]interface{}{"public_id": publicId, "exp": expires.Unix(), "iss": testIssuer, "aud": testAudience}
+}
+
+func testJWTVerifier(cfg jwtConfig) *jwtVerifier {
+	cfg.Issuer = testIssuer
+	cfg.Audience = testAudience
+	v, err := newJWTVerifier(context.Background(), cfg)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	return v
+}
+
 //-----------------------------------------------------------------------------
 // s t a r t   o f   t e s t s
 //-----------------------------------------------------------------------------
@@ -157,10 +240,12 @@ func TestNoContentTypeHeader(t *testing.T) {
 
 	clearTable()
 
+	// gets don't have a body so don't need a content type
 	req, _ := http.NewRequest("GET", "/reviews", nil)
+	req.Header.Set("X-Access-Token", "faketoken")
 	response := executeRequest(req)
 
-	if checkResponseCode(t, http.StatusBadRequest, response.Code) {
+	if checkResponseCode(t, http.StatusNotFound, response.Code) {
 		fmt.Println("[PASS].....TestNoContentTypeHeader")
 	}
 
@@ -175,11 +260,12 @@ func TestWrongContentTypeHeader(t *testing.T) {
 	httpmock.RegisterResponder("GET", os.Getenv("AUTHYURL"),
 		httpmock.NewStringResponder(200, `{"public_id": "f38ba39a-3682-4803-a498-659f0bf05304" }`))
 
-	req, _ := http.NewRequest("GET", "/reviews", nil)
+	req, _ := http.NewRequest("POST", "/reviews", bytes.NewBuffer([]byte(createJson)))
 	req.Header.Set("Content-Type", "application/html; charset=UTF-8")
+	req.Header.Set("X-Access-Token", "faketoken")
 	response := executeRequest(req)
 
-	if checkResponseCode(t, http.StatusBadRequest, response.Code) {
+	if checkResponseCode(t, http.StatusUnsupportedMediaType, response.Code) {
 		fmt.Println("[PASS].....TestWrongContentTypeHeader")
 	}
 
@@ -429,6 +515,11 @@ func TestGetReviewById(t *testing.T) {
 	response := executeRequest(req)
 
 	noError := checkResponseCode(t, http.StatusOK, response.Code)
+	// reviews that aren't deleted don't have a deleted_at
+	if strings.Contains(response.Body.String(), "deleted_at") {
+		noError = false
+		t.Errorf("review has a deleted_at but isn't deleted")
+	}
 	var revResp ReviewsResponse
 	err = json.NewDecoder(response.Body).Decode(&revResp)
 	if err != nil {
@@ -459,7 +550,7 @@ func TestGetReviewById(t *testing.T) {
 		noError = false
 		t.Errorf("post_and_packaging by doesn't match")
 	}
-	if revResp.Reviews[0].Comm != 6 {
+	if revResp.Reviews[0].Comm != 1 {
 		noError = false
 		t.Errorf("communication by doesn't match")
 	}
@@ -674,10 +765,10 @@ func TestCreateReviewOk(t *testing.T) {
 		httpmock.NewStringResponder(200, `{"public_id": "f38ba39a-3682-4803-a498-659f0bf05304" }`))
 
 	httpmock.RegisterResponder("GET", "=~^https://poptape.club/auctionhouse/auction/.",
-		httpmock.NewStringResponder(200, `{"public_id": "f38ba39a-3682-4803-a498-659f0bf05304" }`))
+		httpmock.NewStringResponder(200, auctionJson))
 
 	httpmock.RegisterResponder("GET", "=~^https://poptape.club/items/.",
-		httpmock.NewStringResponder(200, `{"public_id": "f38ba39a-3682-4803-a498-659f0bf05304" }`))
+		httpmock.NewStringResponder(200, itemJson))
 
 	//auction_id, review, overall, pap_cost, communication, as_described)
 	payload := []byte(createJson)
@@ -709,6 +800,7 @@ func TestCreateReviewOk(t *testing.T) {
 
 func TestCreateReviewFailBouncer(t *testing.T) {
 
+	clearTable()
 	httpmock.Activate()
 	defer httpmock.DeactivateAndReset()
 
@@ -810,7 +902,170 @@ func TestCreateReviewFailBadInput2(t *testing.T) {
 
 }
 
-func TestGetReviewsByUserFailNoContentHeader(t *testing.T) {
+func TestCreateReviewFailScoresOutOfRange(t *testing.T) {
+
+	clearTable()
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", os.Getenv("AUTHYURL"),
+		httpmock.NewStringResponder(200, `{"public_id": "f38ba39a-3682-4803-a498-659f0bf05304" }`))
+
+	req, _ := http.NewRequest("POST", "/reviews", bytes.NewBuffer([]byte(createJsonScoreOutOfRange)))
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Access-Token", "faketoken")
+	response := executeRequest(req)
+
+	noError := checkResponseCode(t, http.StatusBadRequest, response.Code)
+	var resp struct {
+		Message string       `json:"message"`
+		Errors  []FieldError `json:"errors"`
+	}
+	err := json.NewDecoder(response.Body).Decode(&resp)
+	if err != nil {
+		noError = false
+		t.Errorf("Error decoding returned JSON: " + err.Error())
+	}
+	if len(resp.Errors) != 2 {
+		noError = false
+		t.Errorf("expected 2 field errors but got [%d]", len(resp.Errors))
+	}
+	fields := map[string]bool{}
+	for _, fe := range resp.Errors {
+		fields[fe.Field] = true
+	}
+	if !fields["overall"] || !fields["post_and_packaging"] {
+		noError = false
+		t.Errorf("field errors [%v] don't match expected", resp.Errors)
+	}
+
+	if noError {
+		fmt.Println("[PASS].....TestCreateReviewFailScoresOutOfRange")
+	}
+}
+
+func TestCreateReviewZeroScoresOk(t *testing.T) {
+
+	clearTable()
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", os.Getenv("AUTHYURL"),
+		httpmock.NewStringResponder(200, `{"public_id": "f38ba39a-3682-4803-a498-659f0bf05304" }`))
+
+	httpmock.RegisterResponder("GET", "=~^https://poptape.club/auctionhouse/auction/.",
+		httpmock.NewStringResponder(200, auctionJson))
+
+	httpmock.RegisterResponder("GET", "=~^https://poptape.club/items/.",
+		httpmock.NewStringResponder(200, itemJson))
+
+	req, _ := http.NewRequest("POST", "/reviews", bytes.NewBuffer([]byte(createJsonZeroScores)))
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Access-Token", "faketoken")
+	response := executeRequest(req)
+
+	noError := checkResponseCode(t, http.StatusCreated, response.Code)
+	var crep CreateReviewResp
+	err := json.NewDecoder(response.Body).Decode(&crep)
+	if err != nil {
+		noError = false
+		t.Errorf("Error decoding returned JSON: " + err.Error())
+	}
+
+	var rv Review
+	a.DB.Where("review_id = ?", crep.ReviewId).First(&rv)
+	if rv.Review != "never arrived" {
+		noError = false
+		t.Errorf("review text [%s] wasn't trimmed", rv.Review)
+	}
+	if rv.Overall != 0 || rv.PapCost != 0 || rv.Comm != 0 || rv.AsDesc != 0 {
+		noError = false
+		t.Errorf("scores weren't all stored as zero")
+	}
+
+	if noError {
+		fmt.Println("[PASS].....TestCreateReviewZeroScoresOk")
+	}
+}
+
+func TestCreateReviewFailReviewSelf(t *testing.T) {
+
+	clearTable()
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", os.Getenv("AUTHYURL"),
+		httpmock.NewStringResponder(200, `{"public_id": "f38ba39a-3682-4803-a498-659f0bf05304" }`))
+
+	req, _ := http.NewRequest("POST", "/reviews", bytes.NewBuffer([]byte(createJsonReviewSelf)))
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Access-Token", "faketoken")
+	response := executeRequest(req)
+
+	noError := checkResponseCode(t, http.StatusBadRequest, response.Code)
+	var resp struct {
+		Message string       `json:"message"`
+		Errors  []FieldError `json:"errors"`
+	}
+	err := json.NewDecoder(response.Body).Decode(&resp)
+	if err != nil {
+		noError = false
+		t.Errorf("Error decoding returned JSON: " + err.Error())
+	}
+	if len(resp.Errors) != 1 || resp.Errors[0].Field != "seller" {
+		noError = false
+		t.Errorf("field errors [%v] don't match expected", resp.Errors)
+	}
+
+	if noError {
+		fmt.Println("[PASS].....TestCreateReviewFailReviewSelf")
+	}
+}
+
+func TestCreateReviewFailReviewTooLong(t *testing.T) {
+
+	clearTable()
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", os.Getenv("AUTHYURL"),
+		httpmock.NewStringResponder(200, `{"public_id": "f38ba39a-3682-4803-a498-659f0bf05304" }`))
+
+	var ri map[string]interface{}
+	_ = json.Unmarshal([]byte(createJson), &ri)
+	ri["review"] = strings.Repeat("a", maxReviewLength+1)
+	payload, _ := json.Marshal(ri)
+
+	req, _ := http.NewRequest("POST", "/reviews", bytes.NewBuffer(payload))
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Access-Token", "faketoken")
+	response := executeRequest(req)
+
+	noError := checkResponseCode(t, http.StatusBadRequest, response.Code)
+	var resp struct {
+		Message string       `json:"message"`
+		Errors  []FieldError `json:"errors"`
+	}
+	err := json.NewDecoder(response.Body).Decode(&resp)
+	if err != nil {
+		noError = false
+		t.Errorf("Error decoding returned JSON: " + err.Error())
+	}
+	if len(resp.Errors) != 1 || resp.Errors[0].Field != "review" {
+		noError = false
+		t.Errorf("field errors [%v] don't match expected", resp.Errors)
+	}
+
+	if noError {
+		fmt.Println("[PASS].....TestCreateReviewFailReviewTooLong")
+	}
+}
+
+func TestGetReviewsByUserFailNotAcceptable(t *testing.T) {
 
 	clearTable()
 	_, err := a.InsertSpecificDummyReviews()
@@ -819,22 +1074,23 @@ func TestGetReviewsByUserFailNoContentHeader(t *testing.T) {
 	}
 
 	req, _ := http.NewRequest("GET", "/reviews/by/user/f38ba39a-3682-4803-a498-659f0bf05304", nil)
+	req.Header.Set("Accept", "text/html, application/xml;q=0.9")
 	response := executeRequest(req)
 
-	noError := checkResponseCode(t, http.StatusBadRequest, response.Code)
+	noError := checkResponseCode(t, http.StatusNotAcceptable, response.Code)
 	var resp RespMessage
 	err = json.NewDecoder(response.Body).Decode(&resp)
 	if err != nil {
 		noError = false
 		t.Errorf("Error decoding returned JSON: " + err.Error())
 	}
-	if resp.Message != "Request must be json" {
+	if resp.Message != "Response can only be json" {
 		noError = false
 		t.Errorf("bad request message [%s] doesn't match expected", resp.Message)
 	}
 
 	if noError {
-		fmt.Println("[PASS].....TestGetReviewsByUserFailNoContentHeader")
+		fmt.Println("[PASS].....TestGetReviewsByUserFailNotAcceptable")
 	}
 
 }
@@ -1136,29 +1392,45 @@ func TestGetMetadataOK(t *testing.T) {
 		t.Errorf("returned public id doesn't match sent id")
 	}
 	ma := roundFloat(mResp.Scores.MetaAverage, 2)
-	if ma != 6.25 {
+	if ma != 3.83 {
 		noError = false
-		t.Errorf("returned MetaAverage [%f] doesn't match expected [6.25]", ma)
+		t.Errorf("returned MetaAverage [%f] doesn't match expected [3.83]", ma)
 	}
 	da := roundFloat(mResp.Scores.AsDescAverage, 2)
-	if da != 6.67 {
+	if da != 4.00 {
 		noError = false
-		t.Errorf("returned AsDescAverage [%f] doesn't match expected [6.67]", da)
+		t.Errorf("returned AsDescAverage [%f] doesn't match expected [4.00]", da)
 	}
 	ca := roundFloat(mResp.Scores.CommAverage, 2)
-	if ca != 6.33 {
+	if ca != 3.00 {
 		noError = false
-		t.Errorf("returned CommAverage [%f] doesn't match expected [6.33]", ca)
+		t.Errorf("returned CommAverage [%f] doesn't match expected [3.00]", ca)
 	}
 	oa := roundFloat(mResp.Scores.OverallAverage, 2)
-	if oa != 6.33 {
+	if oa != 4.00 {
 		noError = false
-		t.Errorf("returned OverallAverage [%f] doesn't match expected [6.33]", oa)
+		t.Errorf("returned OverallAverage [%f] doesn't match expected [4.00]", oa)
 	}
 	pa := roundFloat(mResp.Scores.PapCostAverage, 2)
-	if pa != 5.67 {
+	if pa != 4.33 {
+		noError = false
+		t.Errorf("returned PapCostAverage [%f] doesn't match expected [4.33]", pa)
+	}
+
+	// overall scores for this seller are 5, 4 and 3
+	for score, expected := range map[int]int64{0: 0, 1: 0, 2: 0, 3: 1, 4: 1, 5: 1} {
+		if got, ok := mResp.Distribution.Overall[score]; !ok || got != expected {
+			noError = false
+			t.Errorf("overall distribution for [%d] is [%d] expected [%d]", score, got, expected)
+		}
+	}
+	if len(mResp.Distribution.Comm) != 6 {
+		noError = false
+		t.Errorf("communication distribution has [%d] entries expected [6]", len(mResp.Distribution.Comm))
+	}
+	if mResp.Distribution.Recent.Last30Days != 3 || mResp.Distribution.Recent.Last365Days != 3 {
 		noError = false
-		t.Errorf("returned PapCostAverage [%f] doesn't match expected [5.67]", pa)
+		t.Errorf("recent counts [%v] don't match expected [3]", mResp.Distribution.Recent)
 	}
 
 	if mResp.TotalReviewsByUser != 0 {
@@ -1175,45 +1447,62 @@ func TestGetMetadataOK(t *testing.T) {
 	}
 }
 
-func TestGetMetadataUserDoesNotExist(t *testing.T) {
+func TestGetMetadataNoOtherSellers(t *testing.T) {
 
 	clearTable()
-	_, err := a.InsertSpecificDummyReviews()
-	if err != nil {
+	t.Setenv("SCORE_PRIOR_WEIGHT", "10")
+	seller := uuid.New()
+	for i := 0; i < 3; i++ {
+		rv := Review{ReviewId: uuid.New(), ReviewedBy: uuid.New(), AuctionId: uuid.New(), ItemId: uuid.New(),
+			Seller: seller, Review: "top seller", Overall: 5, PapCost: 5, Comm: 5, AsDesc: 5}
+		if err := a.DB.Create(&rv).Error; err != nil {
+			log.Fatal(err.Error())
+		}
+	}
+	if err := a.RebuildSellerScores(); err != nil {
 		log.Fatal(err.Error())
 	}
 
 	httpmock.Activate()
 	defer httpmock.DeactivateAndReset()
 	httpmock.RegisterResponder("GET", "=~username",
-		httpmock.NewStringResponder(404, `{"foo": "bar"}`))
+		httpmock.NewStringResponder(200, `{"foo": "bar"}`))
 
-	req, _ := http.NewRequest("GET", "/reviews/user/46d7d11c-fa06-4e54-8208-95433b98cfc9", nil)
+	req, _ := http.NewRequest("GET", "/reviews/user/"+seller.String(), nil)
 	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
 	response := executeRequest(req)
 
-	noError := checkResponseCode(t, http.StatusNotFound, response.Code)
+	noError := checkResponseCode(t, http.StatusOK, response.Code)
 
-	var rm RespMessage
-	err = json.NewDecoder(response.Body).Decode(&rm)
-	if err != nil {
+	var mResp MetadataResp
+	if err := json.NewDecoder(response.Body).Decode(&mResp); err != nil {
 		log.Fatal(err.Error())
 	}
-	if rm.Message != "User doesn't exist" {
+
+	// with no other sellers the prior is the middle of the scale, 2.5, so
+	// three fives are (10 * 2.5 + 3 * 5) / 13
+	if mResp.Scores.Bayesian == nil || mResp.Scores.Bayesian.OverallAverage != 3.08 ||
+		mResp.Scores.Bayesian.MetaAverage != 3.08 {
 		noError = false
-		t.Errorf("message returned [%s] doesn't match expected [User doesn't exist]", rm.Message)
+		t.Errorf("returned bayesian scores [%+v] don't match expected [3.08]", mResp.Scores.Bayesian)
 	}
 
 	if noError {
-		fmt.Println("[PASS].....TestGetMetadataUserDoesNotExist")
+		fmt.Println("[PASS].....TestGetMetadataNoOtherSellers")
 	}
 }
 
-func TestGetMetadataOKNoScore(t *testing.T) {
+func TestGetMetadataClampsOldScores(t *testing.T) {
 
 	clearTable()
-	_, err := a.InsertSpecificDummyReviews()
-	if err != nil {
+	seller := uuid.New()
+	rv := Review{ReviewId: uuid.New(), ReviewedBy: uuid.New(), AuctionId: uuid.New(), ItemId: uuid.New(),
+		Seller: seller, Review: "from before scores were checked", Overall: 9, PapCost: -1, Comm: 3, AsDesc: 3}
+	if err := a.DB.Create(&rv).Error; err != nil {
+		log.Fatal(err.Error())
+	}
+	// reviews created directly don't update the seller totals
+	if err := a.RebuildSellerScores(); err != nil {
 		log.Fatal(err.Error())
 	}
 
@@ -1222,117 +1511,261 @@ func TestGetMetadataOKNoScore(t *testing.T) {
 	httpmock.RegisterResponder("GET", "=~username",
 		httpmock.NewStringResponder(200, `{"foo": "bar"}`))
 
-	req, _ := http.NewRequest("GET", "/reviews/user/bbb7d11c-fa06-4e54-8208-95433b98cfc4", nil)
+	req, _ := http.NewRequest("GET", "/reviews/user/"+seller.String(), nil)
 	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
 	response := executeRequest(req)
 
 	noError := checkResponseCode(t, http.StatusOK, response.Code)
 
 	var mResp MetadataResp
-	err = json.NewDecoder(response.Body).Decode(&mResp)
-	if err != nil {
+	if err := json.NewDecoder(response.Body).Decode(&mResp); err != nil {
 		log.Fatal(err.Error())
 	}
 
-	if mResp.PublicId != "bbb7d11c-fa06-4e54-8208-95433b98cfc4" {
-		noError = false
-		t.Errorf("returned public id doesn't match sent id")
-	}
-
-	ma := roundFloat(mResp.Scores.MetaAverage, 2)
-	if ma != 0.00 {
-		noError = false
-		t.Errorf("returned MetaAverage [%f] doesn't match expected [0.00]", ma)
-	}
-
-	if mResp.TotalReviewsByUser != 0 {
+	// out of range scores go in the end buckets
+	if len(mResp.Distribution.Overall) != 6 || mResp.Distribution.Overall[5] != 1 {
 		noError = false
-		t.Errorf("returned reviews by user [%d] doesn't match expected [0]", mResp.TotalReviewsByUser)
+		t.Errorf("overall distribution [%v] expected 6 buckets with [5] = 1", mResp.Distribution.Overall)
 	}
-	if mResp.TotalReviewsOfUser != 1 {
+	if len(mResp.Distribution.PapCost) != 6 || mResp.Distribution.PapCost[0] != 1 {
 		noError = false
-		t.Errorf("returned reviews of user [%d] doesn't match expected [1]", mResp.TotalReviewsOfUser)
+		t.Errorf("post and packaging distribution [%v] expected 6 buckets with [0] = 1", mResp.Distribution.PapCost)
 	}
 
 	if noError {
-		fmt.Println("[PASS].....TestGetMetadataOKNoScore")
+		fmt.Println("[PASS].....TestGetMetadataClampsOldScores")
 	}
 }
 
-func TestGetMetadataFailSC500(t *testing.T) {
+func TestGetMetadataWeightedScores(t *testing.T) {
 
 	clearTable()
-	_, err := a.InsertSpecificDummyReviews()
+	_, err := a.InsertDatedDummyReviews()
 	if err != nil {
 		log.Fatal(err.Error())
 	}
 
+	t.Setenv("SCORE_HALFLIFE_DAYS", "30")
+
 	httpmock.Activate()
 	defer httpmock.DeactivateAndReset()
 	httpmock.RegisterResponder("GET", "=~username",
-		httpmock.NewStringResponder(500, `{"foo": "bar"}`))
+		httpmock.NewStringResponder(200, `{"foo": "bar"}`))
 
-	req, _ := http.NewRequest("GET", "/reviews/user/f38ba39a-3682-4803-a498-659f0bf05304", nil)
+	req, _ := http.NewRequest("GET", "/reviews/user/4a48341f-bcef-4362-9d80-24a4960507ea", nil)
 	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
 	response := executeRequest(req)
 
-	noError := checkResponseCode(t, http.StatusInternalServerError, response.Code)
+	noError := checkResponseCode(t, http.StatusOK, response.Code)
 
-	var mResp RespMessage
+	var mResp MetadataResp
 	err = json.NewDecoder(response.Body).Decode(&mResp)
 	if err != nil {
 		log.Fatal(err.Error())
 	}
 
-	if mResp.Message != "Error fetching username. Status code is [500]" {
+	// two recent 5s and one 1 from two years ago
+	oa := roundFloat(mResp.Scores.OverallAverage, 2)
+	if oa != 3.67 {
 		noError = false
-		t.Errorf("Error fetching username message doesn't match")
+		t.Errorf("returned OverallAverage [%f] doesn't match expected [3.67]", oa)
+	}
+	woa := roundFloat(mResp.WeightedScores.OverallAverage, 2)
+	if woa < 4.99 || woa > 5 {
+		noError = false
+		t.Errorf("returned weighted OverallAverage [%f] should be close to 5", woa)
+	}
+	if mResp.WeightedScores.MetaAverage <= mResp.Scores.MetaAverage {
+		noError = false
+		t.Errorf("weighted MetaAverage [%f] should be higher than MetaAverage [%f]",
+			mResp.WeightedScores.MetaAverage, mResp.Scores.MetaAverage)
 	}
 
 	if noError {
-		fmt.Println("[PASS].....TestGetMetadataFailSC500")
+		fmt.Println("[PASS].....TestGetMetadataWeightedScores")
 	}
 }
 
-func TestGetMetadataFailBadUUID(t *testing.T) {
+func TestGetMetadataUserDoesNotExist(t *testing.T) {
 
 	clearTable()
+	_, err := a.InsertSpecificDummyReviews()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
 
 	httpmock.Activate()
 	defer httpmock.DeactivateAndReset()
 	httpmock.RegisterResponder("GET", "=~username",
-		httpmock.NewStringResponder(200, `{"foo": "bar"}`))
+		httpmock.NewStringResponder(404, `{"foo": "bar"}`))
 
-	req, _ := http.NewRequest("GET", "/reviews/user/f38ba39a-3682-4803-a498-659f0bf0530", nil)
+	req, _ := http.NewRequest("GET", "/reviews/user/46d7d11c-fa06-4e54-8208-95433b98cfc9", nil)
 	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
 	response := executeRequest(req)
 
-	noError := checkResponseCode(t, http.StatusBadRequest, response.Code)
+	noError := checkResponseCode(t, http.StatusNotFound, response.Code)
+
+	var rm RespMessage
+	err = json.NewDecoder(response.Body).Decode(&rm)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	if rm.Message != "User doesn't exist" {
+		noError = false
+		t.Errorf("message returned [%s] doesn't match expected [User doesn't exist]", rm.Message)
+	}
 
 	if noError {
-		fmt.Println("[PASS].....TestGetMetadataFailBadUUID")
+		fmt.Println("[PASS].....TestGetMetadataUserDoesNotExist")
 	}
 }
 
-func TestGetMetadataFailNoContentTypeHdr(t *testing.T) {
+func TestGetMetadataOKFewReviews(t *testing.T) {
 
 	clearTable()
 	_, err := a.InsertSpecificDummyReviews()
 	if err != nil {
 		log.Fatal(err.Error())
 	}
+
+	t.Setenv("SCORE_PRIOR_WEIGHT", "10")
+
 	httpmock.Activate()
 	defer httpmock.DeactivateAndReset()
 	httpmock.RegisterResponder("GET", "=~username",
-		httpmock.NewStringResponder(200, `{}`))
+		httpmock.NewStringResponder(200, `{"foo": "bar"}`))
+
+	req, _ := http.NewRequest("GET", "/reviews/user/bbb7d11c-fa06-4e54-8208-95433b98cfc4", nil)
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	response := executeRequest(req)
+
+	noError := checkResponseCode(t, http.StatusOK, response.Code)
+
+	var mResp MetadataResp
+	err = json.NewDecoder(response.Body).Decode(&mResp)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	if mResp.PublicId != "bbb7d11c-fa06-4e54-8208-95433b98cfc4" {
+		noError = false
+		t.Errorf("returned public id doesn't match sent id")
+	}
+
+	// a single review gets raw averages but the bayesian scores are
+	// pulled towards the average of the other sellers' reviews of 3.05
+	ma := roundFloat(mResp.Scores.MetaAverage, 2)
+	if ma != 4.00 {
+		noError = false
+		t.Errorf("returned MetaAverage [%f] doesn't match expected [4.00]", ma)
+	}
+	if mResp.Scores.ReviewCount != 1 {
+		noError = false
+		t.Errorf("returned ReviewCount [%d] doesn't match expected [1]", mResp.Scores.ReviewCount)
+	}
+	if mResp.Scores.Bayesian == nil {
+		noError = false
+		t.Errorf("no bayesian scores returned")
+	} else {
+		if mResp.Scores.Bayesian.MetaAverage != 3.14 {
+			noError = false
+			t.Errorf("returned bayesian MetaAverage [%f] doesn't match expected [3.14]", mResp.Scores.Bayesian.MetaAverage)
+		}
+		if mResp.Scores.Bayesian.Confidence != 0.09 {
+			noError = false
+			t.Errorf("returned bayesian Confidence [%f] doesn't match expected [0.09]", mResp.Scores.Bayesian.Confidence)
+		}
+	}
+
+	if mResp.TotalReviewsByUser != 0 {
+		noError = false
+		t.Errorf("returned reviews by user [%d] doesn't match expected [0]", mResp.TotalReviewsByUser)
+	}
+	if mResp.TotalReviewsOfUser != 1 {
+		noError = false
+		t.Errorf("returned reviews of user [%d] doesn't match expected [1]", mResp.TotalReviewsOfUser)
+	}
+
+	if noError {
+		fmt.Println("[PASS].....TestGetMetadataOKFewReviews")
+	}
+}
+
+func TestGetMetadataFailSC500(t *testing.T) {
+
+	clearTable()
+	_, err := a.InsertSpecificDummyReviews()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("GET", "=~username",
+		httpmock.NewStringResponder(500, `{"foo": "bar"}`))
 
 	req, _ := http.NewRequest("GET", "/reviews/user/f38ba39a-3682-4803-a498-659f0bf05304", nil)
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	response := executeRequest(req)
+
+	noError := checkResponseCode(t, http.StatusInternalServerError, response.Code)
+
+	var mResp RespMessage
+	err = json.NewDecoder(response.Body).Decode(&mResp)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	if mResp.Message != "Error fetching username. Status code is [500]" {
+		noError = false
+		t.Errorf("Error fetching username message doesn't match")
+	}
+
+	if noError {
+		fmt.Println("[PASS].....TestGetMetadataFailSC500")
+	}
+}
+
+func TestGetMetadataFailBadUUID(t *testing.T) {
+
+	clearTable()
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("GET", "=~username",
+		httpmock.NewStringResponder(200, `{"foo": "bar"}`))
+
+	req, _ := http.NewRequest("GET", "/reviews/user/f38ba39a-3682-4803-a498-659f0bf0530", nil)
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
 	response := executeRequest(req)
 
 	noError := checkResponseCode(t, http.StatusBadRequest, response.Code)
 
 	if noError {
-		fmt.Println("[PASS].....TestGetMetadataFailNoContentTypeHdr")
+		fmt.Println("[PASS].....TestGetMetadataFailBadUUID")
+	}
+}
+
+func TestGetMetadataNoContentTypeHdrOk(t *testing.T) {
+
+	clearTable()
+	_, err := a.InsertSpecificDummyReviews()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("GET", "=~username",
+		httpmock.NewStringResponder(200, `{}`))
+
+	req, _ := http.NewRequest("GET", "/reviews/user/f38ba39a-3682-4803-a498-659f0bf05304", nil)
+	req.Header.Set("Accept", "*/*")
+	response := executeRequest(req)
+
+	noError := checkResponseCode(t, http.StatusOK, response.Code)
+
+	if noError {
+		fmt.Println("[PASS].....TestGetMetadataNoContentTypeHdrOk")
 	}
 }
 
@@ -1449,7 +1882,7 @@ func TestCreateReviewFailFetchItemData(t *testing.T) {
 		httpmock.NewStringResponder(200, `{"public_id": "f38ba39a-3682-4803-a498-659f0bf05304" }`))
 
 	httpmock.RegisterResponder("GET", "=~^https://poptape.club/auctionhouse/auction/.",
-		httpmock.NewStringResponder(200, `{"public_id": "f38ba39a-3682-4803-a498-659f0bf05304" }`))
+		httpmock.NewStringResponder(200, auctionJson))
 
 	httpmock.RegisterResponder("GET", "=~^https://poptape.club/items/.",
 		httpmock.NewStringResponder(500, `{"public_id": "f38ba39a-3682-4803-a498-659f0bf05304" }`))
@@ -1461,9 +1894,9 @@ func TestCreateReviewFailFetchItemData(t *testing.T) {
 	req.Header.Set("X-Access-Token", "faketoken")
 	response := executeRequest(req)
 
-	noError := checkResponseCode(t, http.StatusCreated, response.Code)
+	noError := checkResponseCode(t, http.StatusBadGateway, response.Code)
 
-	if getTotalRecordsInTable() != oldRecCnt+1 {
+	if getTotalRecordsInTable() != oldRecCnt {
 		noError = false
 		t.Errorf("Before and after record counts don't match")
 	}
@@ -1490,7 +1923,7 @@ func TestCreateReviewFailFetchItemBodyNotJson(t *testing.T) {
 		httpmock.NewStringResponder(200, `{"public_id": "f38ba39a-3682-4803-a498-659f0bf05304" }`))
 
 	httpmock.RegisterResponder("GET", "=~^https://poptape.club/auctionhouse/auction/.",
-		httpmock.NewStringResponder(200, `{"public_id": "f38ba39a-3682-4803-a498-659f0bf05304" }`))
+		httpmock.NewStringResponder(200, auctionJson))
 
 	httpmock.RegisterResponder("GET", "=~^https://poptape.club/items/.",
 		httpmock.NewStringResponder(200, `{"public_id: "f38ba39a-3682-4803-a498-659f0bf05304" }`))
@@ -1502,9 +1935,9 @@ func TestCreateReviewFailFetchItemBodyNotJson(t *testing.T) {
 	req.Header.Set("X-Access-Token", "faketoken")
 	response := executeRequest(req)
 
-	noError := checkResponseCode(t, http.StatusCreated, response.Code)
+	noError := checkResponseCode(t, http.StatusBadGateway, response.Code)
 
-	if getTotalRecordsInTable() != oldRecCnt+1 {
+	if getTotalRecordsInTable() != oldRecCnt {
 		noError = false
 		t.Errorf("Before and after record counts don't match")
 	}
@@ -1515,81 +1948,2791 @@ func TestCreateReviewFailFetchItemBodyNotJson(t *testing.T) {
 
 }
 
-// we run these tests last as we have mocked the DB differently to the above tests
+func TestCreateReviewFailItemNotFound(t *testing.T) {
 
-func TestRowsError(t *testing.T) {
-	db, mock, err := sqlmock.New()
-	mock.ExpectClose()
-	require.NoError(t, err)
-	defer func(db *sql.DB) {
-		err := db.Close()
+	clearTable()
+	oldRecCnt := getTotalRecordsInTable()
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", os.Getenv("AUTHYURL"),
+		httpmock.NewStringResponder(200, `{"public_id": "f38ba39a-3682-4803-a498-659f0bf05304" }`))
+
+	httpmock.RegisterResponder("GET", "=~^https://poptape.club/auctionhouse/auction/.",
+		httpmock.NewStringResponder(200, auctionJson))
+
+	httpmock.RegisterResponder("GET", "=~^https://poptape.club/items/.",
+		httpmock.NewStringResponder(404, `{"message": "not found"}`))
+
+	req, _ := http.NewRequest("POST", "/reviews", bytes.NewBuffer([]byte(createJson)))
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Access-Token", "faketoken")
+	response := executeRequest(req)
+
+	noError := checkResponseCode(t, http.StatusNotFound, response.Code)
+
+	if getTotalRecordsInTable() != oldRecCnt {
+		noError = false
+		t.Errorf("Before and after record counts don't match")
+	}
+
+	if noError {
+		fmt.Println("[PASS].....TestCreateReviewFailItemNotFound")
+	}
+}
+
+func TestCreateReviewFailAuctionChecks(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		auction string
+		code    int
+		message string
+	}{
+		{"not finished", auctionJsonNotFinished, http.StatusConflict, "Auction has not finished"},
+		{"item not a lot", auctionJsonItemNotLot, http.StatusUnprocessableEntity, "Item is not a lot in this auction"},
+		{"wrong seller", auctionJsonWrongSeller, http.StatusBadRequest, "Seller doesn't match auction owner"},
+		{"not winner", auctionJsonNotWinner, http.StatusForbidden, "Reviewer did not win this lot"},
+	}
+
+	noError := true
+	for _, tc := range tests {
+
+		clearTable()
+
+		httpmock.Activate()
+
+		httpmock.RegisterResponder("GET", os.Getenv("AUTHYURL"),
+			httpmock.NewStringResponder(200, `{"public_id": "f38ba39a-3682-4803-a498-659f0bf05304" }`))
+
+		httpmock.RegisterResponder("GET", "=~^https://poptape.club/auctionhouse/auction/.",
+			httpmock.NewStringResponder(200, tc.auction))
+
+		httpmock.RegisterResponder("GET", "=~^https://poptape.club/items/.",
+			httpmock.NewStringResponder(200, itemJson))
+
+		req, _ := http.NewRequest("POST", "/reviews", bytes.NewBuffer([]byte(createJson)))
+		req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+		req.Header.Set("X-Access-Token", "faketoken")
+		response := executeRequest(req)
+
+		httpmock.DeactivateAndReset()
+
+		if !checkResponseCode(t, tc.code, response.Code) {
+			noError = false
+			t.Errorf("[%s] returned unexpected status code", tc.name)
+		}
+		var resp RespMessage
+		err := json.NewDecoder(response.Body).Decode(&resp)
 		if err != nil {
-			log.Fatal(err)
+			noError = false
+			t.Errorf("Error decoding returned JSON: " + err.Error())
 		}
-	}(db)
+		if resp.Message != tc.message {
+			noError = false
+			t.Errorf("[%s] message [%s] doesn't match expected [%s]", tc.name, resp.Message, tc.message)
+		}
+		if getTotalRecordsInTable() != 0 {
+			noError = false
+			t.Errorf("[%s] review was created when it shouldn't have been", tc.name)
+		}
+	}
 
-	gormDB, err := gorm.Open(postgres.New(postgres.Config{
-		Conn: db,
-	}), &gorm.Config{})
-	require.NoError(t, err)
+	if noError {
+		fmt.Println("[PASS].....TestCreateReviewFailAuctionChecks")
+	}
+}
 
-	a.DB = gormDB
+func TestCreateReviewDuplicate(t *testing.T) {
 
-	// make the query return an error.
-	mock.ExpectQuery(`SELECT count\(\*\) FROM "reviews" WHERE reviewed_by = \$1`).
-		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(42))
-	mock.ExpectQuery(`SELECT \* FROM "reviews" WHERE reviewed_by = \$1 ORDER BY created desc LIMIT \$2`).
-		WillReturnError(errors.New("forced error"))
+	clearTable()
 
-	req, _ := http.NewRequest("GET", "/reviews/by/user/f38ba39a-3682-4803-a498-659f0bf05304?page=1", nil)
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", os.Getenv("AUTHYURL"),
+		httpmock.NewStringResponder(200, `{"public_id": "f38ba39a-3682-4803-a498-659f0bf05304" }`))
+
+	httpmock.RegisterResponder("GET", "=~^https://poptape.club/auctionhouse/auction/.",
+		httpmock.NewStringResponder(200, auctionJson))
+
+	httpmock.RegisterResponder("GET", "=~^https://poptape.club/items/.",
+		httpmock.NewStringResponder(200, itemJson))
+
+	req, _ := http.NewRequest("POST", "/reviews", bytes.NewBuffer([]byte(createJson)))
 	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Access-Token", "faketoken")
 	response := executeRequest(req)
 
-	noError := checkResponseCode(t, http.StatusBadRequest, response.Code)
-	var resp RespMessage
-	err = json.NewDecoder(response.Body).Decode(&resp)
+	noError := checkResponseCode(t, http.StatusCreated, response.Code)
+	var first CreateReviewResp
+	err := json.NewDecoder(response.Body).Decode(&first)
 	if err != nil {
 		noError = false
 		t.Errorf("Error decoding returned JSON: " + err.Error())
 	}
-	if resp.Message != "Bad request" {
+
+	req, _ = http.NewRequest("POST", "/reviews", bytes.NewBuffer([]byte(createJson)))
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Access-Token", "faketoken")
+	response = executeRequest(req)
+
+	if !checkResponseCode(t, http.StatusConflict, response.Code) {
 		noError = false
-		t.Errorf("Error [%s] doesn't match expected [Bad request]", resp.Message)
+	}
+	var second CreateReviewResp
+	err = json.NewDecoder(response.Body).Decode(&second)
+	if err != nil {
+		noError = false
+		t.Errorf("Error decoding returned JSON: " + err.Error())
+	}
+	if second.ReviewId != first.ReviewId {
+		noError = false
+		t.Errorf("conflicting review id [%s] doesn't match existing [%s]", second.ReviewId, first.ReviewId)
 	}
 
-	if noError {
-		fmt.Println("[PASS].....TestRowsError")
+	if getTotalRecordsInTable() != 1 {
+		noError = false
+		t.Errorf("Expected 1 review but have [%d]", getTotalRecordsInTable())
 	}
 
+	if noError {
+		fmt.Println("[PASS].....TestCreateReviewDuplicate")
+	}
 }
 
-func TestMetaDataCountDBError(t *testing.T) {
-	db, mock, err := sqlmock.New()
-	mock.ExpectClose()
-	require.NoError(t, err)
-	defer func(db *sql.DB) {
-		err := db.Close()
-		if err != nil {
-			log.Fatal(err)
-		}
-	}(db)
+func TestCreateReviewConcurrentDuplicates(t *testing.T) {
+
+	clearTable()
 
 	httpmock.Activate()
 	defer httpmock.DeactivateAndReset()
-	httpmock.RegisterResponder("GET", "=~username",
-		httpmock.NewStringResponder(200, `{}`))
 
-	gormDB, err := gorm.Open(postgres.New(postgres.Config{
-		Conn: db,
-	}), &gorm.Config{})
-	require.NoError(t, err)
+	httpmock.RegisterResponder("GET", os.Getenv("AUTHYURL"),
+		httpmock.NewStringResponder(200, `{"public_id": "f38ba39a-3682-4803-a498-659f0bf05304" }`))
 
-	// make the query return an error.
-	mock.ExpectQuery(`SELECT count\(\*\) FROM "reviews" WHERE seller = \$1`).
-		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(9))
-	mock.ExpectQuery(`SELECT count\(\*\) FROM "reviews" WHERE reviewed_by = \$1`).
-		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(7))
-	mock.ExpectQuery(`SELECT COUNT\(\*\) as review_count, AVG\(overall\) as overall_average, AVG\(pap_cost\) as pap_cost_average, AVG\(comm\) as comm_average, AVG\(as_desc\) as as_desc_average FROM "reviews" WHERE seller = \$1`).
+	httpmock.RegisterResponder("GET", "=~^https://poptape.club/auctionhouse/auction/.",
+		httpmock.NewStringResponder(200, auctionJson))
+
+	httpmock.RegisterResponder("GET", "=~^https://poptape.club/items/.",
+		httpmock.NewStringResponder(200, itemJson))
+
+	const attempts = 5
+	codes := make([]int, attempts)
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+			req, _ := http.NewRequest("POST", "/reviews", bytes.NewBuffer([]byte(createJson)))
+			req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+			req.Header.Set("X-Access-Token", "faketoken")
+			codes[idx] = executeRequest(req).Code
+		}(i)
+	}
+	wg.Wait()
+
+	noError := true
+	created := 0
+	for _, code := range codes {
+		switch code {
+		case http.StatusCreated:
+			created++
+		case http.StatusConflict:
+		default:
+			noError = false
+			t.Errorf("Unexpected status code [%d]", code)
+		}
+	}
+	if created != 1 {
+		noError = false
+		t.Errorf("Expected exactly 1 review to be created but [%d] were", created)
+	}
+	if getTotalRecordsInTable() != 1 {
+		noError = false
+		t.Errorf("Expected 1 review but have [%d]", getTotalRecordsInTable())
+	}
+
+	if noError {
+		fmt.Println("[PASS].....TestCreateReviewConcurrentDuplicates")
+	}
+}
+
+func TestSellerScoresKeptInSync(t *testing.T) {
+
+	clearTable()
+	_, err := a.InsertSpecificDummyReviews()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", os.Getenv("AUTHYURL"),
+		httpmock.NewStringResponder(200, `{"public_id": "f38ba39a-3682-4803-a498-659f0bf05304" }`))
+
+	httpmock.RegisterResponder("GET", "=~^https://poptape.club/auctionhouse/auction/.",
+		httpmock.NewStringResponder(200, auctionJson))
+
+	httpmock.RegisterResponder("GET", "=~^https://poptape.club/items/.",
+		httpmock.NewStringResponder(200, itemJson))
+
+	req, _ := http.NewRequest("POST", "/reviews", bytes.NewBuffer([]byte(createJson)))
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Access-Token", "faketoken")
+	response := executeRequest(req)
+
+	noError := checkResponseCode(t, http.StatusCreated, response.Code)
+
+	ss := getSellerScore("4a48341f-bcef-4362-9d80-24a4960507ea")
+	if ss.ReviewCount != 1 || ss.OverallSum != 4 || ss.PapCostSum != 3 || ss.CommSum != 4 || ss.AsDescSum != 4 {
+		noError = false
+		t.Errorf("seller scores [%+v] not updated on create", ss)
+	}
+
+	req, _ = http.NewRequest("DELETE", "/reviews/e8f48256-2460-418f-81b7-86dad2aa6222", nil)
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Access-Token", "faketoken")
+	response = executeRequest(req)
+
+	if !checkResponseCode(t, http.StatusOK, response.Code) {
+		noError = false
+	}
+
+	// seller had reviews of 5, 4 and 3 overall and the 3 was deleted
+	ss = getSellerScore("46d7d11c-fa06-4e54-8208-95433b98cfc9")
+	if ss.ReviewCount != 2 || ss.OverallSum != 9 {
+		noError = false
+		t.Errorf("seller scores [%+v] not updated on delete", ss)
+	}
+	var sc ScoreCount
+	a.DB.Where("seller = ? AND dimension = ? AND score = ?", "46d7d11c-fa06-4e54-8208-95433b98cfc9", "overall", 3).Find(&sc)
+	if sc.Total != 0 {
+		noError = false
+		t.Errorf("score count [%+v] not updated on delete", sc)
+	}
+
+	// the reviewer wrote 4 reviews, then one more and deleted one
+	if ws := getSellerScore("f38ba39a-3682-4803-a498-659f0bf05304"); ws.WrittenCount != 4 {
+		noError = false
+		t.Errorf("written count [%d] doesn't match expected [4]", ws.WrittenCount)
+	}
+
+	mismatches, err := a.VerifySellerScores()
+	if err != nil {
+		noError = false
+		t.Errorf("Error verifying seller scores: " + err.Error())
+	}
+	if len(mismatches) != 0 {
+		noError = false
+		t.Errorf("seller scores don't match reviews [%+v]", mismatches)
+	}
+
+	if noError {
+		fmt.Println("[PASS].....TestSellerScoresKeptInSync")
+	}
+}
+
+func TestVerifyAndRebuildSellerScores(t *testing.T) {
+
+	clearTable()
+	_, err := a.InsertSpecificDummyReviews()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	noError := true
+	a.DB.Model(&SellerScore{}).
+		Where("seller = ?", "46d7d11c-fa06-4e54-8208-95433b98cfc9").
+		Update("overall_sum", 1)
+
+	if a.RunCommand([]string{"verify-scores"}) != 1 {
+		noError = false
+		t.Errorf("verify-scores should have found a mismatch")
+	}
+	if a.RunCommand([]string{"rebuild-scores"}) != 0 {
+		noError = false
+		t.Errorf("rebuild-scores failed")
+	}
+	if a.RunCommand([]string{"verify-scores"}) != 0 {
+		noError = false
+		t.Errorf("verify-scores found a mismatch after rebuild")
+	}
+
+	// the distribution is checked as well as the totals
+	a.DB.Model(&ScoreCount{}).
+		Where("seller = ? AND dimension = ?", "46d7d11c-fa06-4e54-8208-95433b98cfc9", "communication").
+		Update("total", 7)
+	if a.RunCommand([]string{"verify-scores"}) != 1 {
+		noError = false
+		t.Errorf("verify-scores should have found a distribution mismatch")
+	}
+	if a.RunCommand([]string{"blah"}) != 2 {
+		noError = false
+		t.Errorf("unknown command should return usage exit code")
+	}
+
+	if noError {
+		fmt.Println("[PASS].....TestVerifyAndRebuildSellerScores")
+	}
+}
+
+func TestDeleteReviewIsSoft(t *testing.T) {
+
+	clearTable()
+	_, err := a.InsertSpecificDummyReviews()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	t.Setenv("ADMIN_ROLES", "admin")
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("GET", os.Getenv("AUTHYURL"),
+		httpmock.NewStringResponder(200, `{"public_id": "f38ba39a-3682-4803-a498-659f0bf05304" }`))
+
+	req, _ := http.NewRequest("DELETE", "/reviews/e8f48256-2460-418f-81b7-86dad2aa6222",
+		bytes.NewBuffer([]byte(`{"reason": "wrong item"}`)))
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Access-Token", "faketoken")
+	response := executeRequest(req)
+
+	noError := checkResponseCode(t, http.StatusOK, response.Code)
+
+	var rv Review
+	a.DB.Unscoped().Where("review_id = ?", "e8f48256-2460-418f-81b7-86dad2aa6222").First(&rv)
+	if !rv.DeletedAt.Valid {
+		noError = false
+		t.Errorf("review wasn't marked as deleted")
+	}
+	if rv.DeletedReason != "wrong item" {
+		noError = false
+		t.Errorf("deleted reason [%s] doesn't match expected [wrong item]", rv.DeletedReason)
+	}
+	if rv.DeletedBy == nil || rv.DeletedBy.String() != "f38ba39a-3682-4803-a498-659f0bf05304" {
+		noError = false
+		t.Errorf("deleted by doesn't match the reviewer")
+	}
+
+	// anonymous users can't ask for deleted reviews
+	req, _ = http.NewRequest("GET", "/reviews/e8f48256-2460-418f-81b7-86dad2aa6222?include_deleted=true", nil)
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	response = executeRequest(req)
+
+	if !checkResponseCode(t, http.StatusUnauthorized, response.Code) {
+		noError = false
+	}
+
+	// non admins can't see deleted reviews
+	req, _ = http.NewRequest("GET", "/reviews/e8f48256-2460-418f-81b7-86dad2aa6222?include_deleted=true", nil)
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Access-Token", "faketoken")
+	response = executeRequest(req)
+
+	if !checkResponseCode(t, http.StatusForbidden, response.Code) {
+		noError = false
+	}
+
+	httpmock.RegisterResponder("GET", os.Getenv("AUTHYURL"),
+		httpmock.NewStringResponder(200, `{"public_id": "f38ba39a-3682-4803-a498-659f0bf05000", "roles": ["admin"] }`))
+
+	req, _ = http.NewRequest("GET", "/reviews/e8f48256-2460-418f-81b7-86dad2aa6222?include_deleted=true", nil)
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Access-Token", "adminfaketoken")
+	response = executeRequest(req)
+
+	if !checkResponseCode(t, http.StatusOK, response.Code) {
+		noError = false
+	}
+	var revResp ReviewsResponse
+	err = json.NewDecoder(response.Body).Decode(&revResp)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	if len(revResp.Reviews) != 1 || revResp.Reviews[0].DeletedReason != "wrong item" ||
+		revResp.Reviews[0].Deleted == nil {
+		noError = false
+		t.Errorf("admin didn't get the deleted review")
+	}
+
+	if noError {
+		fmt.Println("[PASS].....TestDeleteReviewIsSoft")
+	}
+}
+
+func TestCreateReviewFailAfterDelete(t *testing.T) {
+
+	clearTable()
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", os.Getenv("AUTHYURL"),
+		httpmock.NewStringResponder(200, `{"public_id": "f38ba39a-3682-4803-a498-659f0bf05304" }`))
+
+	httpmock.RegisterResponder("GET", "=~^https://poptape.club/auctionhouse/auction/.",
+		httpmock.NewStringResponder(200, auctionJson))
+
+	httpmock.RegisterResponder("GET", "=~^https://poptape.club/items/.",
+		httpmock.NewStringResponder(200, itemJson))
+
+	req, _ := http.NewRequest("POST", "/reviews", bytes.NewBuffer([]byte(createJson)))
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Access-Token", "faketoken")
+	response := executeRequest(req)
+
+	noError := checkResponseCode(t, http.StatusCreated, response.Code)
+	var crep CreateReviewResp
+	err := json.NewDecoder(response.Body).Decode(&crep)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	req, _ = http.NewRequest("DELETE", "/reviews/"+crep.ReviewId, nil)
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Access-Token", "faketoken")
+	response = executeRequest(req)
+
+	if !checkResponseCode(t, http.StatusOK, response.Code) {
+		noError = false
+	}
+
+	req, _ = http.NewRequest("POST", "/reviews", bytes.NewBuffer([]byte(createJson)))
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Access-Token", "faketoken")
+	response = executeRequest(req)
+
+	if !checkResponseCode(t, http.StatusConflict, response.Code) {
+		noError = false
+	}
+
+	if noError {
+		fmt.Println("[PASS].....TestCreateReviewFailAfterDelete")
+	}
+}
+
+func TestCreateReplyOk(t *testing.T) {
+
+	clearTable()
+	_, err := a.InsertSpecificDummyReviews()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("GET", os.Getenv("AUTHYURL"),
+		httpmock.NewStringResponder(200, `{"public_id": "46d7d11c-fa06-4e54-8208-95433b98cfc9" }`))
+
+	req, _ := http.NewRequest("POST", "/reviews/e8f48256-2460-418f-81b7-86dad2aa6aaa/reply",
+		bytes.NewBuffer([]byte(`{"reply": "  thanks for your custom  "}`)))
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Access-Token", "faketoken")
+	response := executeRequest(req)
+
+	noError := checkResponseCode(t, http.StatusCreated, response.Code)
+
+	// reply is embedded in lists of reviews
+	req, _ = http.NewRequest("GET", "/reviews/of/user/46d7d11c-fa06-4e54-8208-95433b98cfc9", nil)
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	response = executeRequest(req)
+
+	if !checkResponseCode(t, http.StatusOK, response.Code) {
+		noError = false
+	}
+	var revResp ReviewsResponse
+	err = json.NewDecoder(response.Body).Decode(&revResp)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	replies := 0
+	for _, rv := range revResp.Reviews {
+		if rv.Reply == nil {
+			continue
+		}
+		replies++
+		if rv.ReviewId.String() != "e8f48256-2460-418f-81b7-86dad2aa6aaa" || rv.Reply.Reply != "thanks for your custom" {
+			noError = false
+			t.Errorf("reply [%s] attached to wrong review or not trimmed", rv.Reply.Reply)
+		}
+	}
+	if replies != 1 {
+		noError = false
+		t.Errorf("expected 1 reply but got %d", replies)
+	}
+
+	req, _ = http.NewRequest("GET", "/reviews/e8f48256-2460-418f-81b7-86dad2aa6aaa/reply", nil)
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	response = executeRequest(req)
+
+	if !checkResponseCode(t, http.StatusOK, response.Code) {
+		noError = false
+	}
+
+	// only one reply per review
+	req, _ = http.NewRequest("POST", "/reviews/e8f48256-2460-418f-81b7-86dad2aa6aaa/reply",
+		bytes.NewBuffer([]byte(`{"reply": "thanks again"}`)))
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Access-Token", "faketoken")
+	response = executeRequest(req)
+
+	if !checkResponseCode(t, http.StatusConflict, response.Code) {
+		noError = false
+	}
+
+	if noError {
+		fmt.Println("[PASS].....TestCreateReplyOk")
+	}
+}
+
+func TestCreateReplyFailNotSeller(t *testing.T) {
+
+	clearTable()
+	_, err := a.InsertSpecificDummyReviews()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	// reviewer rather than seller
+	httpmock.RegisterResponder("GET", os.Getenv("AUTHYURL"),
+		httpmock.NewStringResponder(200, `{"public_id": "f38ba39a-3682-4803-a498-659f0bf05304" }`))
+
+	req, _ := http.NewRequest("POST", "/reviews/e8f48256-2460-418f-81b7-86dad2aa6aaa/reply",
+		bytes.NewBuffer([]byte(`{"reply": "i wrote this"}`)))
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Access-Token", "faketoken")
+	response := executeRequest(req)
+
+	noError := checkResponseCode(t, http.StatusForbidden, response.Code)
+
+	var tc int64
+	a.DB.Model(&ReviewReply{}).Count(&tc)
+	if tc != 0 {
+		noError = false
+		t.Errorf("reply was created by someone other than the seller")
+	}
+
+	if noError {
+		fmt.Println("[PASS].....TestCreateReplyFailNotSeller")
+	}
+}
+
+func TestCreateReplyFailBlank(t *testing.T) {
+
+	clearTable()
+	_, err := a.InsertSpecificDummyReviews()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("GET", os.Getenv("AUTHYURL"),
+		httpmock.NewStringResponder(200, `{"public_id": "46d7d11c-fa06-4e54-8208-95433b98cfc9" }`))
+
+	req, _ := http.NewRequest("POST", "/reviews/e8f48256-2460-418f-81b7-86dad2aa6aaa/reply",
+		bytes.NewBuffer([]byte(`{"reply": "     "}`)))
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Access-Token", "faketoken")
+	response := executeRequest(req)
+
+	if checkResponseCode(t, http.StatusBadRequest, response.Code) {
+		fmt.Println("[PASS].....TestCreateReplyFailBlank")
+	}
+}
+
+func TestDeleteReplyOk(t *testing.T) {
+
+	clearTable()
+	_, err := a.InsertSpecificDummyReviews()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	rId, _ := uuid.Parse("e8f48256-2460-418f-81b7-86dad2aa6aaa")
+	seller, _ := uuid.Parse("46d7d11c-fa06-4e54-8208-95433b98cfc9")
+	a.DB.Create(&ReviewReply{ReplyId: uuid.New(), ReviewId: rId, Seller: seller, Reply: "thanks"})
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("GET", os.Getenv("AUTHYURL"),
+		httpmock.NewStringResponder(200, `{"public_id": "f38ba39a-3682-4803-a498-659f0bf05304" }`))
+
+	// only the seller can delete the reply
+	req, _ := http.NewRequest("DELETE", "/reviews/e8f48256-2460-418f-81b7-86dad2aa6aaa/reply", nil)
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Access-Token", "faketoken")
+	response := executeRequest(req)
+
+	noError := checkResponseCode(t, http.StatusForbidden, response.Code)
+
+	httpmock.RegisterResponder("GET", os.Getenv("AUTHYURL"),
+		httpmock.NewStringResponder(200, `{"public_id": "46d7d11c-fa06-4e54-8208-95433b98cfc9" }`))
+
+	req, _ = http.NewRequest("DELETE", "/reviews/e8f48256-2460-418f-81b7-86dad2aa6aaa/reply", nil)
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Access-Token", "sellerfaketoken")
+	response = executeRequest(req)
+
+	if !checkResponseCode(t, http.StatusOK, response.Code) {
+		noError = false
+	}
+
+	req, _ = http.NewRequest("GET", "/reviews/e8f48256-2460-418f-81b7-86dad2aa6aaa/reply", nil)
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	response = executeRequest(req)
+
+	if !checkResponseCode(t, http.StatusNotFound, response.Code) {
+		noError = false
+	}
+
+	if noError {
+		fmt.Println("[PASS].....TestDeleteReplyOk")
+	}
+}
+
+func TestEditReviewOk(t *testing.T) {
+
+	clearTable()
+	_, err := a.InsertSpecificDummyReviews()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("GET", os.Getenv("AUTHYURL"),
+		httpmock.NewStringResponder(200, `{"public_id": "f38ba39a-3682-4803-a498-659f0bf05304" }`))
+
+	before := getSellerScore("46d7d11c-fa06-4e54-8208-95433b98cfc9")
+
+	req, _ := http.NewRequest("PATCH", "/reviews/e8f48256-2460-418f-81b7-86dad2aa6aaa",
+		bytes.NewBuffer([]byte(`{"review": " awesome product ", "overall": 1}`)))
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Access-Token", "faketoken")
+	response := executeRequest(req)
+
+	noError := checkResponseCode(t, http.StatusOK, response.Code)
+
+	var rv Review
+	a.DB.Where("review_id = ?", "e8f48256-2460-418f-81b7-86dad2aa6aaa").First(&rv)
+	if !rv.Edited || rv.EditedAt == nil {
+		noError = false
+		t.Errorf("review wasn't marked as edited")
+	}
+	if rv.Review != "awesome product" || rv.Overall != 1 || rv.PapCost != 4 {
+		noError = false
+		t.Errorf("review wasn't updated as expected")
+	}
+
+	var revisions []ReviewRevision
+	a.DB.Where("review_id = ?", "e8f48256-2460-418f-81b7-86dad2aa6aaa").Find(&revisions)
+	if len(revisions) != 1 || revisions[0].Version != 1 ||
+		revisions[0].Review != "awesome balls product" || revisions[0].Overall != 5 {
+		noError = false
+		t.Errorf("previous version of the review wasn't kept")
+	}
+
+	// seller totals only use the current version
+	after := getSellerScore("46d7d11c-fa06-4e54-8208-95433b98cfc9")
+	if after.ReviewCount != before.ReviewCount || after.OverallSum != before.OverallSum-4 {
+		noError = false
+		t.Errorf("seller totals [%d, %d] not updated from [%d, %d]",
+			after.ReviewCount, after.OverallSum, before.ReviewCount, before.OverallSum)
+	}
+
+	req, _ = http.NewRequest("GET", "/reviews/e8f48256-2460-418f-81b7-86dad2aa6aaa", nil)
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	response = executeRequest(req)
+
+	var revResp ReviewsResponse
+	err = json.NewDecoder(response.Body).Decode(&revResp)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	if len(revResp.Reviews) != 1 || !revResp.Reviews[0].Edited {
+		noError = false
+		t.Errorf("review isn't shown as edited")
+	}
+
+	if noError {
+		fmt.Println("[PASS].....TestEditReviewOk")
+	}
+}
+
+func TestEditReviewFailWindowClosed(t *testing.T) {
+
+	clearTable()
+	_, err := a.InsertSpecificDummyReviews()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	t.Setenv("EDIT_WINDOW_HOURS", "24")
+	a.DB.Model(&Review{}).Where("review_id = ?", "e8f48256-2460-418f-81b7-86dad2aa6aaa").
+		Update("created", time.Now().Add(-25*time.Hour))
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("GET", os.Getenv("AUTHYURL"),
+		httpmock.NewStringResponder(200, `{"public_id": "f38ba39a-3682-4803-a498-659f0bf05304" }`))
+
+	req, _ := http.NewRequest("PATCH", "/reviews/e8f48256-2460-418f-81b7-86dad2aa6aaa",
+		bytes.NewBuffer([]byte(`{"overall": 1}`)))
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Access-Token", "faketoken")
+	response := executeRequest(req)
+
+	noError := checkResponseCode(t, http.StatusForbidden, response.Code)
+
+	var tc int64
+	a.DB.Model(&ReviewRevision{}).Count(&tc)
+	if tc != 0 {
+		noError = false
+		t.Errorf("revision was saved for a failed edit")
+	}
+
+	if noError {
+		fmt.Println("[PASS].....TestEditReviewFailWindowClosed")
+	}
+}
+
+func TestEditReviewFailNotReviewer(t *testing.T) {
+
+	clearTable()
+	_, err := a.InsertSpecificDummyReviews()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("GET", os.Getenv("AUTHYURL"),
+		httpmock.NewStringResponder(200, `{"public_id": "f38ba39a-3682-4803-a498-659f0bf05304" }`))
+
+	// review was written by someone else
+	req, _ := http.NewRequest("PATCH", "/reviews/e8f48256-2460-418f-81b7-86dad2aa6333",
+		bytes.NewBuffer([]byte(`{"overall": 5}`)))
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Access-Token", "faketoken")
+	response := executeRequest(req)
+
+	if checkResponseCode(t, http.StatusNotFound, response.Code) {
+		fmt.Println("[PASS].....TestEditReviewFailNotReviewer")
+	}
+}
+
+func TestEditReviewFailBadInput(t *testing.T) {
+
+	clearTable()
+	_, err := a.InsertSpecificDummyReviews()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("GET", os.Getenv("AUTHYURL"),
+		httpmock.NewStringResponder(200, `{"public_id": "f38ba39a-3682-4803-a498-659f0bf05304" }`))
+
+	noError := true
+	for _, body := range []string{`{"overall": 9}`, `{}`} {
+		req, _ := http.NewRequest("PATCH", "/reviews/e8f48256-2460-418f-81b7-86dad2aa6aaa",
+			bytes.NewBuffer([]byte(body)))
+		req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+		req.Header.Set("X-Access-Token", "faketoken")
+		response := executeRequest(req)
+
+		if !checkResponseCode(t, http.StatusBadRequest, response.Code) {
+			noError = false
+		}
+	}
+
+	if noError {
+		fmt.Println("[PASS].....TestEditReviewFailBadInput")
+	}
+}
+
+func TestBouncerJWTOk(t *testing.T) {
+
+	clearTable()
+	_, err := a.InsertSpecificDummyReviews()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	oldJWT := a.JWT
+	a.JWT = testJWTVerifier(jwtConfig{Secret: "testsecret"})
+	defer func() { a.JWT = oldJWT }()
+
+	// no authy responder so the request fails if authy gets called
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	token := signHS256("testsecret", tokenClaims("f38ba39a-3682-4803-a498-659f0bf05304", time.Now().Add(time.Hour)))
+	req, _ := http.NewRequest("GET", "/reviews", nil)
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Access-Token", token)
+	response := executeRequest(req)
+
+	noError := checkResponseCode(t, http.StatusOK, response.Code)
+	if httpmock.GetTotalCallCount() != 0 {
+		noError = false
+		t.Errorf("authy was called for a valid token")
+	}
+
+	var revResp ReviewsResponse
+	err = json.NewDecoder(response.Body).Decode(&revResp)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	if revResp.TotalReviews != 4 {
+		noError = false
+		t.Errorf("expected 4 reviews for token user but got %d", revResp.TotalReviews)
+	}
+
+	if noError {
+		fmt.Println("[PASS].....TestBouncerJWTOk")
+	}
+}
+
+func TestBouncerJWTFail(t *testing.T) {
+
+	clearTable()
+	oldJWT := a.JWT
+	a.JWT = testJWTVerifier(jwtConfig{Secret: "testsecret"})
+	defer func() { a.JWT = oldJWT }()
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("GET", os.Getenv("AUTHYURL"),
+		httpmock.NewStringResponder(200, `{"public_id": "f38ba39a-3682-4803-a498-659f0bf05304" }`))
+
+	pId := "f38ba39a-3682-4803-a498-659f0bf05304"
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." +
+		base64.RawURLEncoding.EncodeToString([]byte(`{"public_id":"`+pId+`"}`)) + "."
+	tokens := map[string]string{
+		"expired":      signHS256("testsecret", tokenClaims(pId, time.Now().Add(-time.Hour))),
+		"wrong secret": signHS256("notthesecret", tokenClaims(pId, time.Now().Add(time.Hour))),
+		"no expiry":    signHS256("testsecret", map[string]interface{}{"public_id": pId}),
+		"no public id": signHS256("testsecret", map[string]interface{}{"exp": time.Now().Add(time.Hour).Unix(), "iss": testIssuer, "aud": testAudience}),
+		"wrong issuer": signHS256("testsecret", map[string]interface{}{"public_id": pId, "exp": time.Now().Add(time.Hour).Unix(), "iss": "someone", "aud": testAudience}),
+		"wrong aud":    signHS256("testsecret", map[string]interface{}{"public_id": pId, "exp": time.Now().Add(time.Hour).Unix(), "iss": testIssuer, "aud": "other"}),
+		"no aud":       signHS256("testsecret", map[string]interface{}{"public_id": pId, "exp": time.Now().Add(time.Hour).Unix(), "iss": testIssuer}),
+		"alg none":     unsigned,
+		"not a jwt":    "faketoken",
+	}
+
+	noError := true
+	for name, token := range tokens {
+		req, _ := http.NewRequest("GET", "/reviews", nil)
+		req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+		req.Header.Set("X-Access-Token", token)
+		response := executeRequest(req)
+
+		if response.Code != http.StatusUnauthorized {
+			noError = false
+			t.Errorf("[%s] expected response code %d. Got %d", name, http.StatusUnauthorized, response.Code)
+		}
+	}
+	if httpmock.GetTotalCallCount() != 0 {
+		noError = false
+		t.Errorf("authy was called without fallback being set")
+	}
+
+	if noError {
+		fmt.Println("[PASS].....TestBouncerJWTFail")
+	}
+}
+
+func TestBouncerJWTAuthyFallback(t *testing.T) {
+
+	clearTable()
+	_, err := a.InsertSpecificDummyReviews()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	oldJWT := a.JWT
+	a.JWT = testJWTVerifier(jwtConfig{Secret: "testsecret"})
+	defer func() { a.JWT = oldJWT }()
+	t.Setenv("AUTHY_FALLBACK", "true")
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("GET", os.Getenv("AUTHYURL"),
+		httpmock.NewStringResponder(200, `{"public_id": "f38ba39a-3682-4803-a498-659f0bf05304" }`))
+
+	req, _ := http.NewRequest("GET", "/reviews", nil)
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Access-Token", "faketoken")
+	response := executeRequest(req)
+
+	noError := checkResponseCode(t, http.StatusOK, response.Code)
+	if httpmock.GetTotalCallCount() != 1 {
+		noError = false
+		t.Errorf("expected authy to be called once but was called %d times", httpmock.GetTotalCallCount())
+	}
+
+	if noError {
+		fmt.Println("[PASS].....TestBouncerJWTAuthyFallback")
+	}
+}
+
+func TestBouncerJWTRS256Ok(t *testing.T) {
+
+	clearTable()
+	_, err := a.InsertSpecificDummyReviews()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	small, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	jwks, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{{
+		"kty": "RSA",
+		"kid": "testkey",
+		"use": "sig",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString([]byte{1, 0, 1}),
+	}, {
+		"kty": "RSA",
+		"kid": "smallkey",
+		"use": "sig",
+		"n":   base64.RawURLEncoding.EncodeToString(small.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString([]byte{1, 0, 1}),
+	}}})
+	jwksFile := filepath.Join(t.TempDir(), "jwks.json")
+	if err = os.WriteFile(jwksFile, jwks, 0644); err != nil {
+		log.Fatal(err.Error())
+	}
+
+	oldJWT := a.JWT
+	a.JWT = testJWTVerifier(jwtConfig{JWKSFile: jwksFile})
+	defer func() { a.JWT = oldJWT }()
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	token := signRS256(key, "testkey", tokenClaims("f38ba39a-3682-4803-a498-659f0bf05304", time.Now().Add(time.Hour)))
+	req, _ := http.NewRequest("GET", "/reviews", nil)
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Access-Token", token)
+	response := executeRequest(req)
+
+	noError := checkResponseCode(t, http.StatusOK, response.Code)
+
+	// a token signed by another key is rejected
+	other, _ := rsa.GenerateKey(rand.Reader, 2048)
+	token = signRS256(other, "testkey", tokenClaims("f38ba39a-3682-4803-a498-659f0bf05304", time.Now().Add(time.Hour)))
+	req, _ = http.NewRequest("GET", "/reviews", nil)
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Access-Token", token)
+	response = executeRequest(req)
+
+	if !checkResponseCode(t, http.StatusUnauthorized, response.Code) {
+		noError = false
+	}
+
+	// as is one signed by a key that's too small
+	token = signRS256(small, "smallkey", tokenClaims("f38ba39a-3682-4803-a498-659f0bf05304", time.Now().Add(time.Hour)))
+	req, _ = http.NewRequest("GET", "/reviews", nil)
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Access-Token", token)
+	response = executeRequest(req)
+
+	if !checkResponseCode(t, http.StatusUnauthorized, response.Code) {
+		noError = false
+	}
+
+	if noError {
+		fmt.Println("[PASS].....TestBouncerJWTRS256Ok")
+	}
+}
+
+func TestBouncerTokenCache(t *testing.T) {
+
+	clearTable()
+	_, err := a.InsertSpecificDummyReviews()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	oldCache := a.TokenCache
+	a.TokenCache = newTokenCache(10, time.Minute, time.Minute)
+	defer func() { a.TokenCache = oldCache }()
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("GET", os.Getenv("AUTHYURL"),
+		func(req *http.Request) (*http.Response, error) {
+			if req.Header.Get("X-Access-Token") == "badtoken" {
+				return httpmock.NewStringResponse(401, `{}`), nil
+			}
+			return httpmock.NewStringResponse(200, `{"public_id": "f38ba39a-3682-4803-a498-659f0bf05304" }`), nil
+		})
+
+	noError := true
+	for _, token := range []string{"faketoken", "faketoken", "faketoken", "badtoken", "badtoken"} {
+		req, _ := http.NewRequest("GET", "/reviews", nil)
+		req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+		req.Header.Set("X-Access-Token", token)
+		response := executeRequest(req)
+
+		expected := http.StatusOK
+		if token == "badtoken" {
+			expected = http.StatusUnauthorized
+		}
+		if !checkResponseCode(t, expected, response.Code) {
+			noError = false
+		}
+	}
+
+	// each token only gets sent to authy once
+	if httpmock.GetTotalCallCount() != 2 {
+		noError = false
+		t.Errorf("expected authy to be called twice but was called %d times", httpmock.GetTotalCallCount())
+	}
+	st := a.TokenCache.stats()
+	if st.Hits != 2 || st.NegativeHits != 1 || st.Misses != 2 || st.Size != 2 {
+		noError = false
+		t.Errorf("unexpected cache stats %+v", st)
+	}
+
+	if noError {
+		fmt.Println("[PASS].....TestBouncerTokenCache")
+	}
+}
+
+func TestAdminOnlyFail(t *testing.T) {
+
+	clearTable()
+	_, err := a.InsertSpecificDummyReviews()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("GET", os.Getenv("AUTHYURL"),
+		httpmock.NewStringResponder(200, `{"public_id": "f38ba39a-3682-4803-a498-659f0bf05304", "roles": ["user"] }`))
+
+	noError := true
+	for _, r := range []struct{ method, url string }{
+		{"GET", "/reviews/admin/e8f48256-2460-418f-81b7-86dad2aa6aaa"},
+		{"POST", "/reviews/admin/e8f48256-2460-418f-81b7-86dad2aa6aaa/hide"},
+		{"DELETE", "/reviews/admin/e8f48256-2460-418f-81b7-86dad2aa6333"},
+		{"GET", "/reviews/admin/audit"},
+	} {
+		req, _ := http.NewRequest(r.method, r.url, nil)
+		req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+		req.Header.Set("X-Access-Token", "faketoken")
+		response := executeRequest(req)
+
+		if !checkResponseCode(t, http.StatusForbidden, response.Code) {
+			noError = false
+		}
+	}
+
+	if noError {
+		fmt.Println("[PASS].....TestAdminOnlyFail")
+	}
+}
+
+func TestAdminHideReview(t *testing.T) {
+
+	clearTable()
+	_, err := a.InsertSpecificDummyReviews()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("GET", os.Getenv("AUTHYURL"),
+		httpmock.NewStringResponder(200, `{"public_id": "f38ba39a-3682-4803-a498-659f0bf05000", "roles": ["admin"] }`))
+
+	before := getSellerScore("46d7d11c-fa06-4e54-8208-95433b98cfc9")
+
+	req, _ := http.NewRequest("POST", "/reviews/admin/e8f48256-2460-418f-81b7-86dad2aa6aaa/hide",
+		bytes.NewBuffer([]byte(`{"reason": "abusive"}`)))
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Access-Token", "adminfaketoken")
+	response := executeRequest(req)
+
+	noError := checkResponseCode(t, http.StatusOK, response.Code)
+
+	// hidden reviews are left out of lists and scores
+	req, _ = http.NewRequest("GET", "/reviews/e8f48256-2460-418f-81b7-86dad2aa6aaa", nil)
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	response = executeRequest(req)
+
+	if !checkResponseCode(t, http.StatusNotFound, response.Code) {
+		noError = false
+	}
+	hidden := getSellerScore("46d7d11c-fa06-4e54-8208-95433b98cfc9")
+	if hidden.ReviewCount != before.ReviewCount-1 || hidden.OverallSum != before.OverallSum-5 {
+		noError = false
+		t.Errorf("seller totals weren't reduced when review was hidden")
+	}
+
+	// hiding twice is a conflict
+	req, _ = http.NewRequest("POST", "/reviews/admin/e8f48256-2460-418f-81b7-86dad2aa6aaa/hide", nil)
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Access-Token", "adminfaketoken")
+	response = executeRequest(req)
+
+	if !checkResponseCode(t, http.StatusConflict, response.Code) {
+		noError = false
+	}
+
+	req, _ = http.NewRequest("POST", "/reviews/admin/e8f48256-2460-418f-81b7-86dad2aa6aaa/unhide", nil)
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Access-Token", "adminfaketoken")
+	response = executeRequest(req)
+
+	if !checkResponseCode(t, http.StatusOK, response.Code) {
+		noError = false
+	}
+	after := getSellerScore("46d7d11c-fa06-4e54-8208-95433b98cfc9")
+	if !after.sameTotals(before) {
+		noError = false
+		t.Errorf("seller totals weren't restored when review was unhidden")
+	}
+
+	var actions []AdminAction
+	a.DB.Where("review_id = ?", "e8f48256-2460-418f-81b7-86dad2aa6aaa").Order("created").Find(&actions)
+	if len(actions) != 2 || actions[0].Action != "hide" || actions[0].Reason != "abusive" ||
+		actions[1].Action != "unhide" || actions[0].Actor.String() != "f38ba39a-3682-4803-a498-659f0bf05000" {
+		noError = false
+		t.Errorf("admin actions weren't recorded in the audit log")
+	}
+
+	if noError {
+		fmt.Println("[PASS].....TestAdminHideReview")
+	}
+}
+
+func TestAdminDeleteAnyReview(t *testing.T) {
+
+	clearTable()
+	_, err := a.InsertSpecificDummyReviews()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("GET", os.Getenv("AUTHYURL"),
+		httpmock.NewStringResponder(200, `{"public_id": "f38ba39a-3682-4803-a498-659f0bf05000", "roles": ["admin"] }`))
+
+	// admin didn't write this review
+	req, _ := http.NewRequest("DELETE", "/reviews/admin/e8f48256-2460-418f-81b7-86dad2aa6222", nil)
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Access-Token", "adminfaketoken")
+	response := executeRequest(req)
+
+	noError := checkResponseCode(t, http.StatusOK, response.Code)
+
+	var rv Review
+	a.DB.Unscoped().Where("review_id = ?", "e8f48256-2460-418f-81b7-86dad2aa6222").First(&rv)
+	if !rv.DeletedAt.Valid || rv.DeletedReason != "Deleted by admin" ||
+		rv.DeletedBy == nil || rv.DeletedBy.String() != "f38ba39a-3682-4803-a498-659f0bf05000" {
+		noError = false
+		t.Errorf("review wasn't deleted by admin")
+	}
+
+	// admins can still view deleted reviews
+	req, _ = http.NewRequest("GET", "/reviews/admin/e8f48256-2460-418f-81b7-86dad2aa6222", nil)
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Access-Token", "adminfaketoken")
+	response = executeRequest(req)
+
+	if !checkResponseCode(t, http.StatusOK, response.Code) {
+		noError = false
+	}
+
+	mismatches, err := a.VerifySellerScores()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	if len(mismatches) != 0 {
+		noError = false
+		t.Errorf("seller totals out of step after admin delete")
+	}
+
+	req, _ = http.NewRequest("GET", "/reviews/admin/audit?review_id=e8f48256-2460-418f-81b7-86dad2aa6222", nil)
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Access-Token", "adminfaketoken")
+	response = executeRequest(req)
+
+	if !checkResponseCode(t, http.StatusOK, response.Code) {
+		noError = false
+	}
+	var audit struct {
+		Actions []AdminAction `json:"actions"`
+	}
+	err = json.NewDecoder(response.Body).Decode(&audit)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	if len(audit.Actions) != 2 {
+		noError = false
+		t.Errorf("expected a delete and a view in the audit log but got %d actions", len(audit.Actions))
+	}
+
+	if noError {
+		fmt.Println("[PASS].....TestAdminDeleteAnyReview")
+	}
+}
+
+func TestTokenCacheStatsAdminOnly(t *testing.T) {
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("GET", os.Getenv("AUTHYURL"),
+		httpmock.NewStringResponder(200, `{"public_id": "f38ba39a-3682-4803-a498-659f0bf05000", "roles": ["admin"] }`))
+
+	req, _ := http.NewRequest("GET", "/reviews/status", nil)
+	response := executeRequest(req)
+
+	noError := checkResponseCode(t, http.StatusOK, response.Code)
+	if strings.Contains(response.Body.String(), "token_cache") {
+		noError = false
+		t.Errorf("public status shows the token cache stats")
+	}
+
+	req, _ = http.NewRequest("GET", "/reviews/admin/status", nil)
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Access-Token", "adminfaketoken")
+	response = executeRequest(req)
+
+	if !checkResponseCode(t, http.StatusOK, response.Code) {
+		noError = false
+	}
+	if a.TokenCache != nil && !strings.Contains(response.Body.String(), "token_cache") {
+		noError = false
+		t.Errorf("admin status doesn't show the token cache stats")
+	}
+
+	if noError {
+		fmt.Println("[PASS].....TestTokenCacheStatsAdminOnly")
+	}
+}
+
+func TestOptionalAuthBadTokenIsAnonymous(t *testing.T) {
+
+	clearTable()
+	_, err := a.InsertSpecificDummyReviews()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("GET", os.Getenv("AUTHYURL"),
+		httpmock.NewStringResponder(401, `{}`))
+
+	// public reads still work with a token authy doesn't like
+	req, _ := http.NewRequest("GET", "/reviews/e8f48256-2460-418f-81b7-86dad2aa6aaa", nil)
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Access-Token", "badtoken")
+	response := executeRequest(req)
+
+	if checkResponseCode(t, http.StatusOK, response.Code) {
+		fmt.Println("[PASS].....TestOptionalAuthBadTokenIsAnonymous")
+	}
+}
+
+func TestRequestIdEchoed(t *testing.T) {
+
+	req, _ := http.NewRequest("GET", "/reviews/status", nil)
+	req.Header.Set("X-Request-Id", "abc-123.def_456")
+	response := executeRequest(req)
+
+	checkResponseCode(t, http.StatusOK, response.Code)
+	if response.Header().Get("X-Request-Id") != "abc-123.def_456" {
+		t.Errorf("Expected request id to be echoed, got [%s]", response.Header().Get("X-Request-Id"))
+		return
+	}
+	fmt.Println("[PASS].....TestRequestIdEchoed")
+}
+
+func TestRequestIdReplacedIfBad(t *testing.T) {
+
+	req, _ := http.NewRequest("GET", "/reviews/status", nil)
+	req.Header.Set("X-Request-Id", "not ok; drop table")
+	response := executeRequest(req)
+
+	checkResponseCode(t, http.StatusOK, response.Code)
+	if _, err := uuid.Parse(response.Header().Get("X-Request-Id")); err != nil {
+		t.Errorf("Expected a new uuid request id, got [%s]", response.Header().Get("X-Request-Id"))
+		return
+	}
+	fmt.Println("[PASS].....TestRequestIdReplacedIfBad")
+}
+
+func TestAuthRequiredBeforeHandler(t *testing.T) {
+
+	req, _ := http.NewRequest("PATCH", "/reviews/"+uuid.NewString(), bytes.NewBuffer([]byte(`{"overall": 2}`)))
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	response := executeRequest(req)
+
+	if checkResponseCode(t, http.StatusUnauthorized, response.Code) {
+		fmt.Println("[PASS].....TestAuthRequiredBeforeHandler")
+	}
+}
+
+func TestInternalScoresWithApiKey(t *testing.T) {
+
+	clearTable()
+	_, err := a.InsertSpecificDummyReviews()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	_, key, err := a.IssueApiKey("auction", []string{scopeReadScores})
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	req, _ := http.NewRequest("GET", "/reviews/internal/user/46d7d11c-fa06-4e54-8208-95433b98cfc9", nil)
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Api-Key", key)
+	response := executeRequest(req)
+
+	noError := checkResponseCode(t, http.StatusOK, response.Code)
+	var md struct {
+		PublicId string `json:"public_id"`
+		Scores   Scores `json:"scores"`
+	}
+	if err = json.NewDecoder(response.Body).Decode(&md); err != nil {
+		noError = false
+		t.Errorf("Error decoding returned JSON: " + err.Error())
+	}
+	if md.PublicId != "46d7d11c-fa06-4e54-8208-95433b98cfc9" || md.Scores.ReviewCount == 0 {
+		noError = false
+		t.Errorf("Unexpected scores returned [%+v]", md)
+	}
+
+	if noError {
+		fmt.Println("[PASS].....TestInternalScoresWithApiKey")
+	}
+}
+
+func TestInternalFailApiKeys(t *testing.T) {
+
+	clearTable()
+	ak, key, err := a.IssueApiKey("notifications", []string{scopeReadScores})
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	noError := true
+	post := func(key string) int {
+		req, _ := http.NewRequest("POST", "/reviews/internal", bytes.NewBuffer([]byte(createJson)))
+		req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+		if key != "" {
+			req.Header.Set("X-Api-Key", key)
+		}
+		// a user's token is no good here
+		req.Header.Set("X-Access-Token", "faketoken")
+		return executeRequest(req).Code
+	}
+
+	if code := post(""); code != http.StatusUnauthorized {
+		noError = false
+		t.Errorf("Missing key returned [%d]", code)
+	}
+	if code := post("prk_notarealkey"); code != http.StatusUnauthorized {
+		noError = false
+		t.Errorf("Unknown key returned [%d]", code)
+	}
+	if code := post(key); code != http.StatusForbidden {
+		noError = false
+		t.Errorf("Key without scope returned [%d]", code)
+	}
+	if err = a.RevokeApiKey(ak.KeyId); err != nil {
+		log.Fatal(err.Error())
+	}
+	if code := post(key); code != http.StatusUnauthorized {
+		noError = false
+		t.Errorf("Revoked key returned [%d]", code)
+	}
+	if getTotalRecordsInTable() != 0 {
+		noError = false
+		t.Errorf("No review should have been created")
+	}
+
+	if noError {
+		fmt.Println("[PASS].....TestInternalFailApiKeys")
+	}
+}
+
+func TestCreateSystemReviewOk(t *testing.T) {
+
+	clearTable()
+	_, key, err := a.IssueApiKey("auction", []string{scopeReadScores, scopeSystemReview})
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("GET", "=~^https://poptape.club/items/.",
+		httpmock.NewStringResponder(200, itemJson))
+
+	// the reviewer has to have won the lot like any other review
+	httpmock.RegisterResponder("GET", "=~^https://poptape.club/auctionhouse/auction/.",
+		httpmock.NewStringResponder(200, auctionJsonNotWinner))
+
+	req, _ := http.NewRequest("POST", "/reviews/internal", bytes.NewBuffer([]byte(createJson)))
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Api-Key", key)
+	response := executeRequest(req)
+
+	noError := checkResponseCode(t, http.StatusForbidden, response.Code)
+	if getTotalRecordsInTable() != 0 {
+		noError = false
+		t.Errorf("No review should have been created")
+	}
+
+	httpmock.RegisterResponder("GET", "=~^https://poptape.club/auctionhouse/auction/.",
+		httpmock.NewStringResponder(200, auctionJson))
+	httpmock.ZeroCallCounters()
+
+	req, _ = http.NewRequest("POST", "/reviews/internal", bytes.NewBuffer([]byte(createJson)))
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Api-Key", key)
+	response = executeRequest(req)
+
+	if !checkResponseCode(t, http.StatusCreated, response.Code) {
+		noError = false
+	}
+	var crep CreateReviewResp
+	if err = json.NewDecoder(response.Body).Decode(&crep); err != nil {
+		noError = false
+		t.Errorf("Error decoding returned JSON: " + err.Error())
+	}
+	var rv Review
+	a.DB.Where("review_id = ?", crep.ReviewId).Limit(1).Find(&rv)
+	if rv.PostedBy != "auction" {
+		noError = false
+		t.Errorf("Expected review to be posted by auction, got [%s]", rv.PostedBy)
+	}
+	if getSellerScore("4a48341f-bcef-4362-9d80-24a4960507ea").ReviewCount != 1 {
+		noError = false
+		t.Errorf("Seller scores not updated")
+	}
+	// items and auctions are called but not authy
+	if httpmock.GetTotalCallCount() != 2 {
+		noError = false
+		t.Errorf("Expected 2 outside calls, got [%d]", httpmock.GetTotalCallCount())
+	}
+
+	// posting again is a duplicate
+	req, _ = http.NewRequest("POST", "/reviews/internal", bytes.NewBuffer([]byte(createJson)))
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Api-Key", key)
+	response = executeRequest(req)
+	if !checkResponseCode(t, http.StatusConflict, response.Code) {
+		noError = false
+	}
+
+	if noError {
+		fmt.Println("[PASS].....TestCreateSystemReviewOk")
+	}
+}
+
+func TestApiKeysFromFile(t *testing.T) {
+
+	fn := filepath.Join(t.TempDir(), "keys.json")
+	data := `[{"service": "items", "key_hash": "` + hashApiKey("prk_filekey") + `", "scopes": ["reviews:read"]}]`
+	if err := os.WriteFile(fn, []byte(data), 0600); err != nil {
+		log.Fatal(err.Error())
+	}
+	t.Setenv("API_KEYS_FILE", fn)
+	a.InitialiseApiKeys()
+	defer func() {
+		os.Unsetenv("API_KEYS_FILE")
+		a.InitialiseApiKeys()
+	}()
+
+	req, _ := http.NewRequest("GET", "/reviews/internal/user/46d7d11c-fa06-4e54-8208-95433b98cfc9", nil)
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Api-Key", "prk_filekey")
+	response := executeRequest(req)
+
+	if checkResponseCode(t, http.StatusOK, response.Code) {
+		fmt.Println("[PASS].....TestApiKeysFromFile")
+	}
+}
+
+func TestRateLimitWrites(t *testing.T) {
+
+	clearTable()
+	old := a.RateLimiter
+	a.RateLimiter = &rateLimiter{store: newMemoryRateStore(), limits: map[string]rateLimit{
+		"authed": {PerMin: 600, Burst: 100},
+		"write":  {PerMin: 60, Burst: 2},
+	}}
+	defer func() { a.RateLimiter = old }()
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("GET", os.Getenv("AUTHYURL"),
+		httpmock.NewStringResponder(200, `{"public_id": "f38ba39a-3682-4803-a498-659f0bf05304" }`))
+
+	post := func() *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/reviews", bytes.NewBuffer([]byte(`{}`)))
+		req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+		req.Header.Set("X-Access-Token", "faketoken")
+		return executeRequest(req)
+	}
+
+	noError := true
+	for i := 0; i < 2; i++ {
+		response := post()
+		if response.Code == http.StatusTooManyRequests {
+			noError = false
+			t.Errorf("Request [%d] should not have been limited", i)
+		}
+		if response.Header().Get("X-RateLimit-Limit") != "60" {
+			noError = false
+			t.Errorf("Expected X-RateLimit-Limit of 60, got [%s]", response.Header().Get("X-RateLimit-Limit"))
+		}
+	}
+	response := post()
+	if !checkResponseCode(t, http.StatusTooManyRequests, response.Code) {
+		noError = false
+	}
+	if response.Header().Get("Retry-After") != "1" || response.Header().Get("X-RateLimit-Remaining") != "0" {
+		noError = false
+		t.Errorf("Unexpected rate limit headers [%v]", response.Header())
+	}
+
+	// reads have their own limit
+	req, _ := http.NewRequest("GET", "/reviews", nil)
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Access-Token", "faketoken")
+	if executeRequest(req).Code == http.StatusTooManyRequests {
+		noError = false
+		t.Errorf("Reads should not be limited by writes")
+	}
+
+	if noError {
+		fmt.Println("[PASS].....TestRateLimitWrites")
+	}
+}
+
+func TestRateLimitByIP(t *testing.T) {
+
+	old := a.RateLimiter
+	a.RateLimiter = &rateLimiter{store: newMemoryRateStore(), limits: map[string]rateLimit{
+		"public": {PerMin: 60, Burst: 1},
+	}}
+	defer func() { a.RateLimiter = old }()
+
+	get := func(ip string) int {
+		req, _ := http.NewRequest("GET", "/reviews/of/user/"+uuid.NewString(), nil)
+		req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+		req.RemoteAddr = ip + ":4321"
+		return executeRequest(req).Code
+	}
+
+	noError := true
+	if get("10.0.0.1") == http.StatusTooManyRequests {
+		noError = false
+		t.Errorf("First request should not have been limited")
+	}
+	if get("10.0.0.1") != http.StatusTooManyRequests {
+		noError = false
+		t.Errorf("Second request from same ip should have been limited")
+	}
+	if get("10.0.0.2") == http.StatusTooManyRequests {
+		noError = false
+		t.Errorf("Request from another ip should not have been limited")
+	}
+
+	if noError {
+		fmt.Println("[PASS].....TestRateLimitByIP")
+	}
+}
+
+func TestRateLimitBeforeAuth(t *testing.T) {
+
+	old := a.RateLimiter
+	a.RateLimiter = &rateLimiter{store: newMemoryRateStore(), limits: map[string]rateLimit{
+		"auth": {PerMin: 60, Burst: 2},
+	}}
+	defer func() { a.RateLimiter = old }()
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("GET", os.Getenv("AUTHYURL"),
+		httpmock.NewStringResponder(401, `{}`))
+
+	get := func(i int, forwarded string) int {
+		req, _ := http.NewRequest("GET", "/reviews", nil)
+		req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+		req.Header.Set("X-Access-Token", fmt.Sprintf("badtoken%d", i))
+		req.RemoteAddr = "10.0.0.3:4321"
+		if forwarded != "" {
+			req.Header.Set("X-Forwarded-For", forwarded)
+		}
+		return executeRequest(req).Code
+	}
+
+	noError := true
+	for i := 0; i < 2; i++ {
+		if code := get(i, ""); code != http.StatusUnauthorized {
+			noError = false
+			t.Errorf("Request [%d] expected 401 but got [%d]", i, code)
+		}
+	}
+
+	// a made up forwarded ip from an untrusted address doesn't get a new bucket
+	if code := get(2, "192.168.1.1"); code != http.StatusTooManyRequests {
+		noError = false
+		t.Errorf("Expected 429 but got [%d]", code)
+	}
+	if httpmock.GetTotalCallCount() != 2 {
+		noError = false
+		t.Errorf("Expected authy to be called twice but was called %d times", httpmock.GetTotalCallCount())
+	}
+
+	if noError {
+		fmt.Println("[PASS].....TestRateLimitBeforeAuth")
+	}
+}
+
+func TestRateLimitBucketRefills(t *testing.T) {
+
+	now := time.Now()
+	ms := newMemoryRateStore()
+	ms.now = func() time.Time { return now }
+	lim := rateLimit{PerMin: 6, Burst: 2}
+
+	noError := true
+	for i, want := range []bool{true, true, false} {
+		res, _ := ms.Take("k", lim, 1)
+		if res.Allowed != want {
+			noError = false
+			t.Errorf("Take [%d] allowed [%t] expected [%t]", i, res.Allowed, want)
+		}
+	}
+	res, _ := ms.Take("k", lim, 1)
+	if res.RetryAfter != 10*time.Second || res.Reset != 20*time.Second {
+		noError = false
+		t.Errorf("Unexpected retry after [%s] and reset [%s]", res.RetryAfter, res.Reset)
+	}
+
+	// one token every 10 seconds
+	now = now.Add(10 * time.Second)
+	if res, _ = ms.Take("k", lim, 1); !res.Allowed {
+		noError = false
+		t.Errorf("Bucket should have refilled by one")
+	}
+
+	// full buckets are swept away
+	now = now.Add(2 * rateSweepInterval)
+	ms.Take("other", lim, 1)
+	if _, ok := ms.buckets["k"]; ok {
+		noError = false
+		t.Errorf("Full bucket should have been swept")
+	}
+
+	if noError {
+		fmt.Println("[PASS].....TestRateLimitBucketRefills")
+	}
+}
+
+func TestContentTypeVariants(t *testing.T) {
+
+	tests := []struct {
+		ct   string
+		code int
+	}{
+		{"application/json", http.StatusCreated},
+		{"Application/JSON; Charset=utf-8", http.StatusCreated},
+		{"application/json;charset=UTF8", http.StatusCreated},
+		{"application/vnd.poptape+json", http.StatusCreated},
+		{"application/json; charset=klingon", http.StatusUnsupportedMediaType},
+		{"text/json", http.StatusUnsupportedMediaType},
+		{"", http.StatusUnsupportedMediaType},
+	}
+
+	noError := true
+	for _, tc := range tests {
+
+		clearTable()
+
+		httpmock.Activate()
+		httpmock.RegisterResponder("GET", os.Getenv("AUTHYURL"),
+			httpmock.NewStringResponder(200, `{"public_id": "f38ba39a-3682-4803-a498-659f0bf05304" }`))
+		httpmock.RegisterResponder("GET", "=~^https://poptape.club/auctionhouse/auction/.",
+			httpmock.NewStringResponder(200, auctionJson))
+		httpmock.RegisterResponder("GET", "=~^https://poptape.club/items/.",
+			httpmock.NewStringResponder(200, itemJson))
+
+		req, _ := http.NewRequest("POST", "/reviews", bytes.NewBuffer([]byte(createJson)))
+		if tc.ct != "" {
+			req.Header.Set("Content-Type", tc.ct)
+		}
+		req.Header.Set("X-Access-Token", "faketoken")
+		response := executeRequest(req)
+
+		httpmock.DeactivateAndReset()
+
+		if response.Code != tc.code {
+			noError = false
+			t.Errorf("Content type [%s] returned [%d] expected [%d]", tc.ct, response.Code, tc.code)
+		}
+	}
+
+	if noError {
+		fmt.Println("[PASS].....TestContentTypeVariants")
+	}
+}
+
+func TestCreateReviewLatin1Body(t *testing.T) {
+
+	clearTable()
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("GET", os.Getenv("AUTHYURL"),
+		httpmock.NewStringResponder(200, `{"public_id": "f38ba39a-3682-4803-a498-659f0bf05304" }`))
+	httpmock.RegisterResponder("GET", "=~^https://poptape.club/auctionhouse/auction/.",
+		httpmock.NewStringResponder(200, auctionJson))
+	httpmock.RegisterResponder("GET", "=~^https://poptape.club/items/.",
+		httpmock.NewStringResponder(200, itemJson))
+
+	// 0xe9 is é in iso-8859-1 but isn't valid utf-8 on its own
+	payload := strings.Replace(createJson, "amazing product", "tr\xe8s bien, caf\xe9", 1)
+	req, _ := http.NewRequest("POST", "/reviews", bytes.NewBuffer([]byte(payload)))
+	req.Header.Set("Content-Type", "application/json; charset=ISO-8859-1")
+	req.Header.Set("X-Access-Token", "faketoken")
+	response := executeRequest(req)
+
+	noError := checkResponseCode(t, http.StatusCreated, response.Code)
+	var rv Review
+	a.DB.Where("reviewed_by = ?", "f38ba39a-3682-4803-a498-659f0bf05304").Limit(1).Find(&rv)
+	if rv.Review != "très bien, café" {
+		noError = false
+		t.Errorf("Review text [%s] wasn't converted to utf-8", rv.Review)
+	}
+
+	if noError {
+		fmt.Println("[PASS].....TestCreateReviewLatin1Body")
+	}
+}
+
+func TestAcceptHeaders(t *testing.T) {
+
+	tests := []struct {
+		accept string
+		code   int
+	}{
+		{"", http.StatusOK},
+		{"application/json", http.StatusOK},
+		{"text/html, application/*;q=0.8", http.StatusOK},
+		{"application/problem+json", http.StatusOK},
+		{"text/html, */*;q=0.1", http.StatusOK},
+		{"text/html", http.StatusNotAcceptable},
+		{"application/json;q=0, text/plain", http.StatusNotAcceptable},
+		{"application/json;q=0, */*", http.StatusNotAcceptable},
+		{"*/*;q=0, application/json", http.StatusOK},
+		{"application/*;q=0, */*;q=0.5", http.StatusNotAcceptable},
+		{"application/json;q=0.0", http.StatusNotAcceptable},
+	}
+
+	noError := true
+	for _, tc := range tests {
+		req, _ := http.NewRequest("GET", "/reviews/internal/user/"+uuid.NewString(), nil)
+		if tc.accept != "" {
+			req.Header.Set("Accept", tc.accept)
+		}
+		// the negotiation is done before the api key is checked
+		response := executeRequest(req)
+		code := response.Code
+		if code == http.StatusUnauthorized {
+			code = http.StatusOK
+		}
+		if code != tc.code {
+			noError = false
+			t.Errorf("Accept [%s] returned [%d] expected [%d]", tc.accept, response.Code, tc.code)
+		}
+	}
+
+	if noError {
+		fmt.Println("[PASS].....TestAcceptHeaders")
+	}
+}
+
+func getReviewsPage(t *testing.T, url string) (int, ReviewsResponse) {
+
+	req, _ := http.NewRequest("GET", url, nil)
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	response := executeRequest(req)
+
+	var revResp ReviewsResponse
+	if response.Code == http.StatusOK {
+		if err := json.NewDecoder(response.Body).Decode(&revResp); err != nil {
+			t.Errorf("Error decoding returned JSON: " + err.Error())
+		}
+	}
+	return response.Code, revResp
+}
+
+func TestCursorPagination(t *testing.T) {
+
+	clearTable()
+	_, err := a.InsertSpecificDummyReviews()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	base := "/reviews/by/user/f38ba39a-3682-4803-a498-659f0bf05304?pagesize=1&cursor="
+	noError := true
+	var ids []uuid.UUID
+	cursor := ""
+	for i := 0; i < 10; i++ {
+		code, revResp := getReviewsPage(t, base+cursor)
+		if code != http.StatusOK || len(revResp.Reviews) != 1 {
+			noError = false
+			t.Errorf("Page [%d] returned [%d] with [%d] reviews", i, code, len(revResp.Reviews))
+			break
+		}
+		ids = append(ids, revResp.Reviews[0].ReviewId)
+		if i == 0 {
+			// a new review shouldn't shift the pages we haven't seen yet
+			rv := Review{ReviewId: uuid.New(), ReviewedBy: revResp.Reviews[0].ReviewedBy,
+				AuctionId: uuid.New(), ItemId: uuid.New(), Seller: uuid.New(), Overall: 3}
+			a.DB.Create(&rv)
+			if revResp.PrevCursor != "" {
+				noError = false
+				t.Errorf("First page shouldn't have a prev cursor")
+			}
+		}
+		if revResp.NextCursor == "" {
+			break
+		}
+		if len(revResp.URLS) == 0 || !strings.Contains(revResp.URLS[len(revResp.URLS)-1].NextURL, "cursor="+revResp.NextCursor) {
+			noError = false
+			t.Errorf("Next url doesn't have the cursor [%v]", revResp.URLS)
+		}
+		cursor = revResp.NextCursor
+	}
+	if len(ids) != 4 {
+		noError = false
+		t.Errorf("Expected 4 pages but got [%d]", len(ids))
+	}
+	seen := map[uuid.UUID]bool{}
+	for _, id := range ids {
+		if seen[id] {
+			noError = false
+			t.Errorf("Review [%s] was returned twice", id)
+		}
+		seen[id] = true
+	}
+
+	// and back again from the last page
+	code, revResp := getReviewsPage(t, base+cursor)
+	for i := len(ids) - 2; i >= 0 && code == http.StatusOK; i-- {
+		if revResp.PrevCursor == "" {
+			noError = false
+			t.Errorf("Missing prev cursor going back to page [%d]", i)
+			break
+		}
+		code, revResp = getReviewsPage(t, base+revResp.PrevCursor)
+		if len(revResp.Reviews) != 1 || revResp.Reviews[0].ReviewId != ids[i] {
+			noError = false
+			t.Errorf("Going back to page [%d] didn't return the same review", i)
+		}
+	}
+
+	if noError {
+		fmt.Println("[PASS].....TestCursorPagination")
+	}
+}
+
+func TestCursorPaginationPastEnd(t *testing.T) {
+
+	clearTable()
+	_, err := a.InsertSpecificDummyReviews()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	base := "/reviews/by/user/f38ba39a-3682-4803-a498-659f0bf05304?pagesize=2&cursor="
+	noError := true
+	_, first := getReviewsPage(t, base)
+	if len(first.Reviews) != 2 || first.NextCursor == "" {
+		log.Fatal("first page should have 2 reviews and a next cursor")
+	}
+	// the rest of the reviews go before the next page is fetched
+	res := a.DB.Where("reviewed_by = ? AND review_id NOT IN ?", first.Reviews[0].ReviewedBy,
+		[]uuid.UUID{first.Reviews[0].ReviewId, first.Reviews[1].ReviewId}).Delete(&Review{})
+	if res.Error != nil {
+		log.Fatal(res.Error.Error())
+	}
+
+	code, revResp := getReviewsPage(t, base+first.NextCursor)
+	if code != http.StatusOK || revResp.Reviews == nil || len(revResp.Reviews) != 0 {
+		noError = false
+		t.Errorf("Page past the end returned [%d] with reviews [%v]", code, revResp.Reviews)
+	}
+	if revResp.Links == nil || revResp.Links.First == "" || revResp.Links.Prev == "" || revResp.PrevCursor == "" {
+		noError = false
+		t.Errorf("Page past the end is missing links [%v]", revResp.Links)
+	}
+	if len(revResp.URLS) == 0 || revResp.NextCursor != "" {
+		noError = false
+		t.Errorf("Page past the end has urls [%v] and next cursor [%s]", revResp.URLS, revResp.NextCursor)
+	}
+
+	// prev goes back to the page the cursor came from
+	_, prevResp := getReviewsPage(t, base+revResp.PrevCursor)
+	if len(prevResp.Reviews) != 2 || prevResp.Reviews[0].ReviewId != first.Reviews[0].ReviewId ||
+		prevResp.Reviews[1].ReviewId != first.Reviews[1].ReviewId {
+		noError = false
+		t.Errorf("Prev from past the end didn't return the first page [%v]", prevResp.Reviews)
+	}
+
+	if noError {
+		fmt.Println("[PASS].....TestCursorPaginationPastEnd")
+	}
+}
+
+func TestCursorPaginationFail(t *testing.T) {
+
+	clearTable()
+	_, err := a.InsertSpecificDummyReviews()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	noError := true
+	base := "/reviews/by/user/f38ba39a-3682-4803-a498-659f0bf05304"
+	if code, _ := getReviewsPage(t, base+"?cursor=notarealcursor"); code != http.StatusBadRequest {
+		noError = false
+		t.Errorf("Bad cursor returned [%d]", code)
+	}
+	if code, _ := getReviewsPage(t, base+"?cursor=&page=2"); code != http.StatusBadRequest {
+		noError = false
+		t.Errorf("Cursor and page returned [%d]", code)
+	}
+	// cursors are tied to the sort order they were made with
+	_, revResp := getReviewsPage(t, base+"?pagesize=1&cursor=")
+	if code, _ := getReviewsPage(t, base+"?sort=asc&cursor="+revResp.NextCursor); code != http.StatusBadRequest {
+		noError = false
+		t.Errorf("Cursor with a different sort returned [%d]", code)
+	}
+	if code, _ := getReviewsPage(t, "/reviews/by/user/"+uuid.NewString()+"?cursor="); code != http.StatusNotFound {
+		noError = false
+		t.Errorf("No reviews returned [%d]", code)
+	}
+
+	if noError {
+		fmt.Println("[PASS].....TestCursorPaginationFail")
+	}
+}
+
+func TestOrderByAndFilters(t *testing.T) {
+
+	clearTable()
+	_, err := a.InsertDatedDummyReviews()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	base := "/reviews/of/user/4a48341f-bcef-4362-9d80-24a4960507ea"
+
+	noError := true
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"?orderby=overall&sort=asc", []string{"rubbish", "brill", "great"}},
+		{"?orderby=post_and_packaging", []string{"great", "brill", "rubbish"}},
+		{"?min_overall=5", []string{"brill", "great"}},
+		{"?max_overall=1", []string{"rubbish"}},
+		{"?created_after=" + time.Now().AddDate(-1, 0, 0).Format(time.DateOnly), []string{"brill", "great"}},
+		{"?created_before=" + time.Now().AddDate(0, 0, -2).UTC().Format(time.RFC3339), []string{"rubbish"}},
+		{"?has_text=true&sort=asc", []string{"rubbish", "great", "brill"}},
+	}
+	for _, tc := range tests {
+		code, revResp := getReviewsPage(t, base+tc.query)
+		var got []string
+		for _, r := range revResp.Reviews {
+			got = append(got, r.Review)
+		}
+		if code != http.StatusOK || strings.Join(got, ",") != strings.Join(tc.want, ",") {
+			noError = false
+			t.Errorf("Query [%s] returned [%d] %v expected %v", tc.query, code, got, tc.want)
+		}
+	}
+
+	if code, _ := getReviewsPage(t, base+"?has_text=false"); code != http.StatusNotFound {
+		noError = false
+		t.Errorf("has_text=false returned [%d] expected 404", code)
+	}
+	for _, q := range []string{"?orderby=seller", "?orderby=helpful", "?min_overall=6", "?min_overall=4&max_overall=2",
+		"?created_after=yesterday", "?has_text=maybe", "?orderby=overall&cursor="} {
+		if code, _ := getReviewsPage(t, base+q); code != http.StatusBadRequest {
+			noError = false
+			t.Errorf("Query [%s] returned [%d] expected 400", q, code)
+		}
+	}
+
+	if noError {
+		fmt.Println("[PASS].....TestOrderByAndFilters")
+	}
+}
+
+func TestFieldsAndEmbeds(t *testing.T) {
+
+	clearTable()
+	_, err := a.InsertSpecificDummyReviews()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	req, _ := http.NewRequest("GET", "/reviews/by/user/f38ba39a-3682-4803-a498-659f0bf05304?fields=overall,seller&embed=seller_scores", nil)
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	response := executeRequest(req)
+	noError := checkResponseCode(t, http.StatusOK, response.Code)
+
+	var resp struct {
+		TotalReviews int                      `json:"total_reviews"`
+		Reviews      []map[string]interface{} `json:"reviews"`
+		SellerScores map[string]Scores        `json:"seller_scores"`
+	}
+	if err = json.NewDecoder(response.Body).Decode(&resp); err != nil {
+		t.Errorf("Error decoding returned JSON: " + err.Error())
+	}
+	if resp.TotalReviews != 4 || len(resp.Reviews) != 4 {
+		noError = false
+		t.Errorf("Returned [%d] reviews expected [4]", len(resp.Reviews))
+	}
+	for _, r := range resp.Reviews {
+		if len(r) != 3 || r["review_id"] == nil || r["overall"] == nil || r["seller"] == nil {
+			noError = false
+			t.Errorf("Review [%v] doesn't have just the fields asked for", r)
+		}
+	}
+
+	// sellers are 46d7d11c with overall scores 5, 4 and 3 and aaaaaaaa with 2
+	ss, ok := resp.SellerScores["46d7d11c-fa06-4e54-8208-95433b98cfc9"]
+	if len(resp.SellerScores) != 2 || !ok || ss.ReviewCount != 3 || ss.OverallAverage != 4 || ss.Bayesian == nil {
+		noError = false
+		t.Errorf("Unexpected seller scores [%v]", resp.SellerScores)
+	}
+	if resp.SellerScores["aaaaaaaa-fa06-4e54-8208-95433b98cfc9"].ReviewCount != 1 {
+		noError = false
+		t.Errorf("Unexpected seller scores [%v]", resp.SellerScores)
+	}
+
+	// fields work with cursors too
+	code, revResp := getReviewsPage(t, "/reviews/by/user/f38ba39a-3682-4803-a498-659f0bf05304?fields=review&cursor=&pagesize=2")
+	if code != http.StatusOK || len(revResp.Reviews) != 2 || revResp.NextCursor == "" ||
+		revResp.Reviews[0].Review == "" || revResp.Reviews[0].Overall != 0 {
+		noError = false
+		t.Errorf("Unexpected cursor page [%d] [%v]", code, revResp.Reviews)
+	}
+
+	for _, q := range []string{"fields=", "fields=overall,password", "fields=snippet", "embed=reviewer_scores"} {
+		code, _ = getReviewsPage(t, "/reviews/by/user/f38ba39a-3682-4803-a498-659f0bf05304?"+q)
+		if code != http.StatusBadRequest {
+			noError = false
+			t.Errorf("[%s] returned [%d] expected 400", q, code)
+		}
+	}
+
+	if noError {
+		fmt.Println("[PASS].....TestFieldsAndEmbeds")
+	}
+}
+
+func TestBatchReviews(t *testing.T) {
+
+	clearTable()
+	_, err := a.InsertSpecificDummyReviews()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	type batchResp struct {
+		By      string `json:"by"`
+		Found   int    `json:"found"`
+		Results map[string]struct {
+			Status  int      `json:"status"`
+			Message string   `json:"message"`
+			Reviews []Review `json:"reviews"`
+		} `json:"results"`
+	}
+
+	req, _ := http.NewRequest("GET", "/reviews/batch?id=e8f48256-2460-418f-81b7-86dad2aa6e41&id=e8f48256-2460-418f-81b7-86dad2aa6aaa&id=e8f48256-2460-418f-81b7-86dad2aa6999&id=bob", nil)
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	response := executeRequest(req)
+	noError := checkResponseCode(t, http.StatusOK, response.Code)
+
+	var br batchResp
+	if err = json.NewDecoder(response.Body).Decode(&br); err != nil {
+		t.Errorf("Error decoding returned JSON: " + err.Error())
+	}
+	if br.By != "review" || br.Found != 2 || len(br.Results) != 4 {
+		noError = false
+		t.Errorf("Unexpected batch response [%+v]", br)
+	}
+	if r := br.Results["e8f48256-2460-418f-81b7-86dad2aa6aaa"]; r.Status != http.StatusOK || len(r.Reviews) != 1 || r.Reviews[0].Review != "awesome balls product" {
+		noError = false
+		t.Errorf("Unexpected batch result [%+v]", r)
+	}
+	if br.Results["e8f48256-2460-418f-81b7-86dad2aa6999"].Status != http.StatusNotFound ||
+		br.Results["bob"].Status != http.StatusBadRequest {
+		noError = false
+		t.Errorf("Missing and bad ids not marked [%+v]", br.Results)
+	}
+
+	// batches of auctions have all the reviews for each auction
+	var rv Review
+	a.DB.First(&rv, "review_id = ?", "e8f48256-2460-418f-81b7-86dad2aa6e41")
+	payload := []byte(`{"by":"auction","ids":["` + rv.AuctionId.String() + `"]}`)
+	req, _ = http.NewRequest("POST", "/reviews/batch", bytes.NewBuffer(payload))
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	response = executeRequest(req)
+	if !checkResponseCode(t, http.StatusOK, response.Code) {
+		noError = false
+	}
+	br = batchResp{}
+	if err = json.NewDecoder(response.Body).Decode(&br); err != nil {
+		t.Errorf("Error decoding returned JSON: " + err.Error())
+	}
+	r := br.Results[rv.AuctionId.String()]
+	if br.Found != 1 || r.Status != http.StatusOK || len(r.Reviews) == 0 {
+		noError = false
+		t.Errorf("Unexpected auction batch [%+v]", br)
+	}
+	for _, rev := range r.Reviews {
+		if rev.AuctionId != rv.AuctionId {
+			noError = false
+			t.Errorf("Review [%s] is from the wrong auction", rev.ReviewId)
+		}
+	}
+
+	if noError {
+		fmt.Println("[PASS].....TestBatchReviews")
+	}
+}
+
+func TestBatchReviewsFail(t *testing.T) {
+
+	clearTable()
+	t.Setenv("BATCH_MAX_IDS", "2")
+
+	noError := true
+	for _, url := range []string{
+		"/reviews/batch",
+		"/reviews/batch?by=seller&id=e8f48256-2460-418f-81b7-86dad2aa6e41",
+		"/reviews/batch?id=a&id=b&id=c",
+	} {
+		req, _ := http.NewRequest("GET", url, nil)
+		req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+		response := executeRequest(req)
+		if !checkResponseCode(t, http.StatusBadRequest, response.Code) {
+			noError = false
+		}
+	}
+
+	for _, payload := range []string{`{}`, `{"ids":[]}`, `{"ids":"bob"}`, `{"by":"seller","ids":["bob"]}`} {
+		req, _ := http.NewRequest("POST", "/reviews/batch", bytes.NewBuffer([]byte(payload)))
+		req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+		response := executeRequest(req)
+		if !checkResponseCode(t, http.StatusBadRequest, response.Code) {
+			noError = false
+		}
+	}
+
+	if noError {
+		fmt.Println("[PASS].....TestBatchReviewsFail")
+	}
+}
+
+func TestUsersMetadata(t *testing.T) {
+
+	clearTable()
+	_, err := a.InsertSpecificDummyReviews()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("GET", "=~username/f38ba39a",
+		httpmock.NewStringResponder(200, `{"foo": "bar"}`))
+	httpmock.RegisterResponder("GET", "=~username/46d7d11c",
+		httpmock.NewStringResponder(200, `{"foo": "bar"}`))
+	httpmock.RegisterResponder("GET", "=~username/00000000",
+		httpmock.NewStringResponder(404, `{"message": "not found"}`))
+	httpmock.RegisterResponder("GET", "=~username/11111111",
+		httpmock.NewStringResponder(500, `{"message": "oops"}`))
+
+	users := []string{
+		"46d7d11c-fa06-4e54-8208-95433b98cfc9",
+		"f38ba39a-3682-4803-a498-659f0bf05304",
+		"00000000-fa06-4e54-8208-95433b98cfc9",
+		"11111111-fa06-4e54-8208-95433b98cfc9",
+		"bob",
+	}
+	payload, _ := json.Marshal(UsersMetadataInput{PublicIds: users})
+	req, _ := http.NewRequest("POST", "/reviews/users/metadata", bytes.NewBuffer(payload))
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	response := executeRequest(req)
+	noError := checkResponseCode(t, http.StatusOK, response.Code)
+
+	var resp struct {
+		Results map[string]struct {
+			Status   int           `json:"status"`
+			Message  string        `json:"message"`
+			Metadata *MetadataResp `json:"metadata"`
+		} `json:"results"`
+	}
+	if err = json.NewDecoder(response.Body).Decode(&resp); err != nil {
+		t.Errorf("Error decoding returned JSON: " + err.Error())
+	}
+	if len(resp.Results) != len(users) {
+		noError = false
+		t.Errorf("Returned [%d] results expected [%d]", len(resp.Results), len(users))
+	}
+	for id, sc := range map[string]int{users[2]: http.StatusNotFound, users[3]: http.StatusBadGateway, users[4]: http.StatusBadRequest} {
+		if resp.Results[id].Status != sc || resp.Results[id].Metadata != nil {
+			noError = false
+			t.Errorf("Result for [%s] is [%+v] expected [%d]", id, resp.Results[id], sc)
+		}
+	}
+
+	// each user's metadata should be the same as fetching it on its own
+	for _, id := range users[:2] {
+		req, _ = http.NewRequest("GET", "/reviews/user/"+id, nil)
+		req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+		response = executeRequest(req)
+		var single MetadataResp
+		if err = json.NewDecoder(response.Body).Decode(&single); err != nil {
+			t.Errorf("Error decoding returned JSON: " + err.Error())
+		}
+		r := resp.Results[id]
+		if r.Status != http.StatusOK || r.Metadata == nil {
+			noError = false
+			t.Errorf("No metadata for [%s] [%+v]", id, r)
+			continue
+		}
+		want, _ := json.Marshal(single)
+		got, _ := json.Marshal(r.Metadata)
+		if string(want) != string(got) {
+			noError = false
+			t.Errorf("Batch metadata [%s] doesn't match [%s]", got, want)
+		}
+	}
+
+	if noError {
+		fmt.Println("[PASS].....TestUsersMetadata")
+	}
+}
+
+func TestUsersMetadataFail(t *testing.T) {
+
+	clearTable()
+	t.Setenv("BATCH_MAX_IDS", "2")
+
+	noError := true
+	for _, payload := range []string{`{}`, `{"public_ids":[]}`, `{"public_ids":"bob"}`, `{"public_ids":["a","b","c"]}`} {
+		req, _ := http.NewRequest("POST", "/reviews/users/metadata", bytes.NewBuffer([]byte(payload)))
+		req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+		response := executeRequest(req)
+		if !checkResponseCode(t, http.StatusBadRequest, response.Code) {
+			noError = false
+		}
+	}
+
+	if noError {
+		fmt.Println("[PASS].....TestUsersMetadataFail")
+	}
+}
+
+func TestUsersMetadataFailMalformedJson(t *testing.T) {
+
+	clearTable()
+
+	req, _ := http.NewRequest("POST", "/reviews/users/metadata", bytes.NewBuffer([]byte(`{"public_ids":`)))
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	response := executeRequest(req)
+
+	noError := checkResponseCode(t, http.StatusBadRequest, response.Code)
+	var resp map[string]interface{}
+	err := json.NewDecoder(response.Body).Decode(&resp)
+	if err != nil {
+		noError = false
+		t.Errorf("Error decoding returned JSON: " + err.Error())
+	}
+	// there are no field errors for broken json so errors should be left out
+	// rather than sent as null
+	if _, ok := resp["errors"]; ok {
+		noError = false
+		t.Errorf("errors [%v] should not be in the response", resp["errors"])
+	}
+
+	if noError {
+		fmt.Println("[PASS].....TestUsersMetadataFailMalformedJson")
+	}
+}
+
+func TestUsersMetadataRateLimit(t *testing.T) {
+
+	clearTable()
+	old := a.RateLimiter
+	a.RateLimiter = &rateLimiter{store: newMemoryRateStore(), limits: map[string]rateLimit{
+		"public": {PerMin: 60, Burst: 5},
+	}}
+	defer func() { a.RateLimiter = old }()
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("GET", "=~username",
+		httpmock.NewStringResponder(200, `{"foo": "bar"}`))
+
+	post := func(n int) int {
+		var users []string
+		for i := 0; i < n; i++ {
+			users = append(users, uuid.NewString())
+		}
+		payload, _ := json.Marshal(UsersMetadataInput{PublicIds: users})
+		req, _ := http.NewRequest("POST", "/reviews/users/metadata", bytes.NewBuffer(payload))
+		req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+		req.RemoteAddr = "10.0.0.4:4321"
+		return executeRequest(req).Code
+	}
+
+	// each user counts as a request so 6 is more than is left after the post
+	noError := true
+	if code := post(6); code != http.StatusTooManyRequests {
+		noError = false
+		t.Errorf("Expected 429 but got [%d]", code)
+	}
+	if httpmock.GetTotalCallCount() != 0 {
+		noError = false
+		t.Errorf("Expected authy not to be called but was called %d times", httpmock.GetTotalCallCount())
+	}
+	if code := post(3); code != http.StatusOK {
+		noError = false
+		t.Errorf("Expected 200 but got [%d]", code)
+	}
+	if httpmock.GetTotalCallCount() != 3 {
+		noError = false
+		t.Errorf("Expected authy to be called 3 times but was called %d times", httpmock.GetTotalCallCount())
+	}
+
+	if noError {
+		fmt.Println("[PASS].....TestUsersMetadataRateLimit")
+	}
+}
+
+func getWithHeaders(url string, hdrs map[string]string) *httptest.ResponseRecorder {
+
+	req, _ := http.NewRequest("GET", url, nil)
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	for k, v := range hdrs {
+		req.Header.Set(k, v)
+	}
+	return executeRequest(req)
+}
+
+func TestHTTPCaching(t *testing.T) {
+
+	clearTable()
+	_, err := a.InsertSpecificDummyReviews()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	url := "/reviews/e8f48256-2460-418f-81b7-86dad2aa6e41"
+	response := getWithHeaders(url, nil)
+	noError := checkResponseCode(t, http.StatusOK, response.Code)
+	etag := response.Header().Get("ETag")
+	lastMod := response.Header().Get("Last-Modified")
+	if etag == "" || lastMod == "" || response.Header().Get("Cache-Control") != "public, max-age=60" {
+		noError = false
+		t.Errorf("Missing cache headers [%v]", response.Header())
+	}
+
+	// the same content gets the same etag
+	response = getWithHeaders(url, nil)
+	if response.Header().Get("ETag") != etag || response.Header().Get("Last-Modified") != lastMod {
+		noError = false
+		t.Errorf("Cache headers changed [%v]", response.Header())
+	}
+
+	for _, hdrs := range []map[string]string{
+		{"If-None-Match": etag},
+		{"If-None-Match": `"abc", ` + etag},
+		{"If-None-Match": "*"},
+		{"If-Modified-Since": lastMod},
+	} {
+		response = getWithHeaders(url, hdrs)
+		if response.Code != http.StatusNotModified || response.Body.Len() != 0 || response.Header().Get("ETag") != etag {
+			noError = false
+			t.Errorf("Headers [%v] returned [%d] expected 304", hdrs, response.Code)
+		}
+	}
+
+	// If-None-Match wins over If-Modified-Since
+	response = getWithHeaders(url, map[string]string{"If-None-Match": `"abc"`, "If-Modified-Since": lastMod})
+	if !checkResponseCode(t, http.StatusOK, response.Code) {
+		noError = false
+	}
+
+	// an edit changes the review so the etag and last modified move on.
+	// Last-Modified only has seconds so the edit is put a second later
+	a.DB.Model(&Review{}).Where("review_id = ?", "e8f48256-2460-418f-81b7-86dad2aa6e41").
+		Updates(map[string]interface{}{"review": "changed my mind", "edited": true, "updated": time.Now().Add(time.Second)})
+	response = getWithHeaders(url, map[string]string{"If-None-Match": etag})
+	if !checkResponseCode(t, http.StatusOK, response.Code) {
+		noError = false
+	}
+	if response.Header().Get("ETag") == etag {
+		noError = false
+		t.Errorf("ETag didn't change when review did")
+	}
+	response = getWithHeaders(url, map[string]string{"If-Modified-Since": lastMod})
+	if !checkResponseCode(t, http.StatusOK, response.Code) {
+		noError = false
+	}
+
+	// lists have their own cache control and users' responses aren't public
+	response = getWithHeaders("/reviews/of/user/46d7d11c-fa06-4e54-8208-95433b98cfc9", nil)
+	if response.Header().Get("Cache-Control") != "public, max-age=30" || response.Header().Get("ETag") == "" {
+		noError = false
+		t.Errorf("Unexpected list cache headers [%v]", response.Header())
+	}
+	response = getWithHeaders(url, map[string]string{"X-Access-Token": "sometoken"})
+	if response.Header().Get("Cache-Control") != "private, no-cache" {
+		noError = false
+		t.Errorf("Unexpected cache control [%s] with token", response.Header().Get("Cache-Control"))
+	}
+
+	// errors aren't cached but still vary on the token
+	response = getWithHeaders("/reviews/e8f48256-2460-418f-81b7-86dad2aa6999", nil)
+	if response.Code != http.StatusNotFound || response.Header().Get("ETag") != "" {
+		noError = false
+		t.Errorf("Not found returned [%d] with etag [%s]", response.Code, response.Header().Get("ETag"))
+	}
+	if response.Header().Get("Vary") != "X-Access-Token" {
+		noError = false
+		t.Errorf("Expected Vary on a not found but got [%s]", response.Header().Get("Vary"))
+	}
+
+	// hiding a review changes the list it was in
+	list := "/reviews/of/user/46d7d11c-fa06-4e54-8208-95433b98cfc9"
+	response = getWithHeaders(list, nil)
+	listMod := response.Header().Get("Last-Modified")
+	if listMod == "" {
+		noError = false
+		t.Errorf("Missing Last-Modified on list [%v]", response.Header())
+	}
+	a.DB.Model(&Review{}).Where("review_id = ?", "e8f48256-2460-418f-81b7-86dad2aa6222").
+		Updates(map[string]interface{}{"hidden": true, "updated": time.Now().Add(2 * time.Second)})
+	response = getWithHeaders(list, map[string]string{"If-Modified-Since": listMod})
+	if response.Code != http.StatusOK || response.Header().Get("Last-Modified") == listMod {
+		noError = false
+		t.Errorf("Hiding a review returned [%d] with Last-Modified [%s]", response.Code, response.Header().Get("Last-Modified"))
+	}
+
+	if noError {
+		fmt.Println("[PASS].....TestHTTPCaching")
+	}
+}
+
+func TestPaginationLinks(t *testing.T) {
+
+	clearTable()
+	_, err := a.InsertSpecificDummyReviews()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	t.Setenv("PREVNEXTURL", "https://prevnext.com")
+
+	base := "https://prevnext.com/reviews/by/user/f38ba39a-3682-4803-a498-659f0bf05304?orderby=overall&page="
+	req, _ := http.NewRequest("GET", "/reviews/by/user/f38ba39a-3682-4803-a498-659f0bf05304?page=2&pagesize=1&orderby=overall&sort=asc", nil)
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	response := executeRequest(req)
+	noError := checkResponseCode(t, http.StatusOK, response.Code)
+
+	var revResp ReviewsResponse
+	if err = json.NewDecoder(response.Body).Decode(&revResp); err != nil {
+		t.Errorf("Error decoding returned JSON: " + err.Error())
+	}
+
+	// every query param is kept, only the page changes
+	want := Links{
+		First: base + "1&pagesize=1&sort=asc",
+		Prev:  base + "1&pagesize=1&sort=asc",
+		Next:  base + "3&pagesize=1&sort=asc",
+		Last:  base + "4&pagesize=1&sort=asc",
+	}
+	if revResp.Links == nil || *revResp.Links != want {
+		noError = false
+		t.Errorf("Links [%+v] don't match expected [%+v]", revResp.Links, want)
+	}
+	if len(revResp.URLS) != 2 || revResp.URLS[0].PrevURL != want.Prev || revResp.URLS[1].NextURL != want.Next {
+		noError = false
+		t.Errorf("Urls [%+v] don't match the links", revResp.URLS)
+	}
+	link := fmt.Sprintf(`<%s>; rel="first", <%s>; rel="prev", <%s>; rel="next", <%s>; rel="last"`,
+		want.First, want.Prev, want.Next, want.Last)
+	if response.Header().Get("Link") != link {
+		noError = false
+		t.Errorf("Link header [%s] doesn't match expected [%s]", response.Header().Get("Link"), link)
+	}
+
+	// the first page has no prev
+	code, revResp := getReviewsPage(t, "/reviews/by/user/f38ba39a-3682-4803-a498-659f0bf05304?pagesize=2")
+	if code != http.StatusOK || revResp.Links == nil || revResp.Links.Prev != "" ||
+		!strings.HasSuffix(revResp.Links.Last, "page=2&pagesize=2") {
+		noError = false
+		t.Errorf("Unexpected first page links [%+v]", revResp.Links)
+	}
+
+	// the client's host and forwarded headers are never used
+	req, _ = http.NewRequest("GET", "/reviews/by/user/f38ba39a-3682-4803-a498-659f0bf05304?pagesize=2", nil)
+	req.Host = "evil.example.com"
+	req.Header.Set("X-Forwarded-Proto", "http")
+	req.Header.Set("X-Forwarded-Host", "evil.example.com")
+	response = executeRequest(req)
+	if !strings.HasPrefix(response.Header().Get("Link"), "<https://prevnext.com/reviews/by/user/") {
+		noError = false
+		t.Errorf("Link header [%s] doesn't use PREVNEXTURL", response.Header().Get("Link"))
+	}
+
+	// a search is kept in the page urls like any other query
+	t.Setenv("PAGESIZE", "1")
+	code, revResp = getReviewsPage(t, "/reviews/search?q=changed+OR+superduper&page=2")
+	if code != http.StatusOK || len(revResp.URLS) != 2 ||
+		!strings.Contains(revResp.URLS[1].NextURL, "q=changed+OR+superduper") {
+		noError = false
+		t.Errorf("Search urls [%v] don't have the search", revResp.URLS)
+	}
+
+	// cursor pages have first, prev and next but no last
+	req, _ = http.NewRequest("GET", "/reviews/by/user/f38ba39a-3682-4803-a498-659f0bf05304?cursor=&pagesize=2", nil)
+	response = executeRequest(req)
+	link = response.Header().Get("Link")
+	if !strings.Contains(link, `?cursor=&pagesize=2>; rel="first"`) || !strings.Contains(link, `rel="next"`) ||
+		strings.Contains(link, `rel="last"`) {
+		noError = false
+		t.Errorf("Unexpected cursor Link header [%s]", link)
+	}
+
+	if noError {
+		fmt.Println("[PASS].....TestPaginationLinks")
+	}
+}
+
+func TestSearchReviews(t *testing.T) {
+
+	clearTable()
+	_, err := a.InsertSpecificDummyReviews()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	noError := true
+	tests := []struct {
+		query string
+		total int
+	}{
+		{"q=balls", 2},
+		{"q=%22balls+product%22", 2},
+		{"q=%22product+balls%22", 0},
+		{"q=balls+-awesome", 1},
+		{"q=changed+OR+superduper", 3},
+		{"q=changing", 2},
+		{"q=changed&seller=46d7d11c-fa06-4e54-8208-95433b98cfc9", 1},
+		{"q=balls&reviewed_by=f38ba39a-3682-4803-a498-659f0bf05000", 1},
+		{"q=balls&created_before=2000-01-01", 0},
+	}
+	for _, tc := range tests {
+		code, revResp := getReviewsPage(t, "/reviews/search?"+tc.query)
+		if tc.total == 0 {
+			if code != http.StatusNotFound {
+				noError = false
+				t.Errorf("Search [%s] returned [%d] expected 404", tc.query, code)
+			}
+			continue
+		}
+		if code != http.StatusOK || revResp.TotalReviews != tc.total || len(revResp.Reviews) != tc.total {
+			noError = false
+			t.Errorf("Search [%s] returned [%d] with [%d] reviews expected [%d]", tc.query, code, revResp.TotalReviews, tc.total)
+		}
+	}
+
+	code, revResp := getReviewsPage(t, "/reviews/search?q=awesome")
+	if code != http.StatusOK || len(revResp.Reviews) != 1 ||
+		revResp.Reviews[0].Snippet != "<mark>awesome</mark> balls product" {
+		noError = false
+		t.Errorf("Unexpected snippet [%v]", revResp.Reviews)
+	}
+
+	for _, q := range []string{"", "q=", "q=" + strings.Repeat("a", 201), "q=balls&seller=bob", "q=balls&min_overall=9"} {
+		if code, _ = getReviewsPage(t, "/reviews/search?"+q); code != http.StatusBadRequest {
+			noError = false
+			t.Errorf("Search [%.20s] returned [%d] expected 400", q, code)
+		}
+	}
+
+	if noError {
+		fmt.Println("[PASS].....TestSearchReviews")
+	}
+}
+
+func TestMigrateRemovesDuplicateReviews(t *testing.T) {
+
+	clearTable()
+	// a db from before the unique index could have duplicate reviews
+	if err := a.DB.Migrator().DropIndex(&Review{}, "idx_reviewer_auction_item"); err != nil {
+		log.Fatal(err.Error())
+	}
+
+	reviewer, auction, item, seller := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	now := time.Now()
+	reviews := []Review{
+		{Review: "first", Overall: 5, PapCost: 5, Comm: 5, AsDesc: 5, Created: now.Add(-time.Hour)},
+		{Review: "second", Overall: 1, PapCost: 1, Comm: 1, AsDesc: 1, Created: now},
+	}
+	for i := range reviews {
+		reviews[i].ReviewId = uuid.New()
+		reviews[i].ReviewedBy = reviewer
+		reviews[i].AuctionId = auction
+		reviews[i].ItemId = item
+		reviews[i].Seller = seller
+	}
+	if err := a.DB.Create(&reviews).Error; err != nil {
+		log.Fatal(err.Error())
+	}
+	reply := ReviewReply{ReplyId: uuid.New(), ReviewId: reviews[1].ReviewId, Seller: seller, Reply: "thanks"}
+	if err := a.DB.Create(&reply).Error; err != nil {
+		log.Fatal(err.Error())
+	}
+
+	a.MigrateModels()
+
+	noError := true
+	var left []Review
+	if err := a.DB.Unscoped().Where("seller = ?", seller).Find(&left).Error; err != nil {
+		log.Fatal(err.Error())
+	}
+	if len(left) != 1 || left[0].ReviewId != reviews[0].ReviewId {
+		noError = false
+		t.Errorf("reviews left [%v] expected only [%s]", left, reviews[0].ReviewId)
+	}
+	if !a.DB.Migrator().HasIndex(&Review{}, "idx_reviewer_auction_item") {
+		noError = false
+		t.Errorf("unique index wasn't created")
+	}
+	var replies int64
+	a.DB.Model(&ReviewReply{}).Where("review_id = ?", reviews[1].ReviewId).Count(&replies)
+	if replies != 0 {
+		noError = false
+		t.Errorf("reply of the removed review wasn't deleted")
+	}
+	var ss SellerScore
+	a.DB.Where("seller = ?", seller).Find(&ss)
+	if ss.ReviewCount != 1 || ss.OverallSum != 5 {
+		noError = false
+		t.Errorf("seller score [%+v] expected 1 review with overall sum 5", ss)
+	}
+
+	if noError {
+		fmt.Println("[PASS].....TestMigrateRemovesDuplicateReviews")
+	}
+}
+
+// we run these tests last as we have mocked the DB differently to the above tests
+
+func TestRowsError(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	mock.ExpectClose()
+	require.NoError(t, err)
+	defer func(db *sql.DB) {
+		err := db.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}(db)
+
+	gormDB, err := gorm.Open(postgres.New(postgres.Config{
+		Conn: db,
+	}), &gorm.Config{})
+	require.NoError(t, err)
+
+	a.DB = gormDB
+
+	// make the query return an error.
+	mock.ExpectQuery(`SELECT MAX\(GREATEST\(created, edited_at, deleted_at, updated\)\) FROM "reviews" WHERE reviewed_by = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(time.Now()))
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "reviews" WHERE reviewed_by = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(42))
+	mock.ExpectQuery(`SELECT \* FROM "reviews" WHERE reviewed_by = \$1 AND hidden = \$2 AND "reviews"."deleted_at" IS NULL ORDER BY created desc, review_id desc LIMIT \$3`).
+		WillReturnError(errors.New("forced error"))
+
+	req, _ := http.NewRequest("GET", "/reviews/by/user/f38ba39a-3682-4803-a498-659f0bf05304?page=1", nil)
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	response := executeRequest(req)
+
+	noError := checkResponseCode(t, http.StatusBadRequest, response.Code)
+	var resp RespMessage
+	err = json.NewDecoder(response.Body).Decode(&resp)
+	if err != nil {
+		noError = false
+		t.Errorf("Error decoding returned JSON: " + err.Error())
+	}
+	if resp.Message != "Bad request" {
+		noError = false
+		t.Errorf("Error [%s] doesn't match expected [Bad request]", resp.Message)
+	}
+
+	if noError {
+		fmt.Println("[PASS].....TestRowsError")
+	}
+
+}
+
+func TestMetaDataCountDBError(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	mock.ExpectClose()
+	require.NoError(t, err)
+	defer func(db *sql.DB) {
+		err := db.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}(db)
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("GET", "=~username",
+		httpmock.NewStringResponder(200, `{}`))
+
+	gormDB, err := gorm.Open(postgres.New(postgres.Config{
+		Conn: db,
+	}), &gorm.Config{})
+	require.NoError(t, err)
+
+	// make the query return an error.
+	mock.ExpectQuery(`SELECT \* FROM "seller_scores" WHERE seller IN \(\$1\)`).
 		WillReturnError(errors.New("forced error"))
 	a.DB = gormDB
 
@@ -1632,7 +4775,8 @@ func TestFetchReviewsRowsCloseError(t *testing.T) {
 	require.NoError(t, err)
 	a.DB = gormDB
 
-	// Set up for count
+	// Set up for last modified and count
+	mock.ExpectQuery("SELECT MAX").WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(time.Now()))
 	mock.ExpectQuery("SELECT count").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
 
 	// Set up for rows
@@ -3,6 +3,9 @@ package main
 import (
 	"encoding/json"
 	"github.com/go-faker/faker/v4"
+	"github.com/google/uuid"
+	"math/rand"
+	"time"
 )
 
 func (a *App) InsertFakedDummyReviews(numRevs int) ([]Review, error) {
@@ -13,12 +16,25 @@ func (a *App) InsertFakedDummyReviews(numRevs int) ([]Review, error) {
 		a.Log.Info().Msgf("Error faking data [%s]", err.Error())
 		return nil, err
 	}
+	// faker picks any int so keep the scores in range
+	for i := range reviews {
+		reviews[i].Overall = minScore + rand.Intn(maxScore-minScore+1)
+		reviews[i].PapCost = minScore + rand.Intn(maxScore-minScore+1)
+		reviews[i].Comm = minScore + rand.Intn(maxScore-minScore+1)
+		reviews[i].AsDesc = minScore + rand.Intn(maxScore-minScore+1)
+	}
 	res := a.DB.Create(&reviews)
 	if res.Error != nil {
 		a.Log.Info().Msgf("Reviews creation failed: [%s]", err.Error())
 		return nil, res.Error
 	}
 
+	// reviews created directly don't update the seller totals
+	err = a.RebuildSellerScores()
+	if err != nil {
+		return nil, err
+	}
+
 	return reviews, nil
 }
 
@@ -59,8 +75,8 @@ func (a *App) InsertSpecificDummyReviews() ([]Review, error) {
 			"seller": "46d7d11c-fa06-4e54-8208-95433b98cfc9",
 			"overall": 4,
 			"post_and_packaging": 4,
-			"communication": 7,
-			"as_described": 8
+			"communication": 2,
+			"as_described": 5
 		},
 		{
 			"review_id": "e8f48256-2460-418f-81b7-86dad2aa6222",
@@ -69,10 +85,10 @@ func (a *App) InsertSpecificDummyReviews() ([]Review, error) {
 			"auction_id": "e77be9e0-bb00-49bc-9e7d-d7cc7072ab22",
 			"item_id": "aabbccd6-9be8-441f-ad86-d86e5faddd81",
 			"seller": "46d7d11c-fa06-4e54-8208-95433b98cfc9",
-			"overall": 10,
-			"post_and_packaging": 9,
-			"communication": 8,
-			"as_described": 9
+			"overall": 3,
+			"post_and_packaging": 5,
+			"communication": 3,
+			"as_described": 4
 		},
 		{
 			"review_id": "e8f48256-2460-418f-81b7-86dad2aa6333",
@@ -83,7 +99,7 @@ func (a *App) InsertSpecificDummyReviews() ([]Review, error) {
 			"seller": "f38ba39a-3682-4803-a498-659f0bf05304",
 			"overall": 2,
 			"post_and_packaging": 2,
-			"communication": 6,
+			"communication": 1,
 			"as_described": 1
 		},
 		{
@@ -94,8 +110,8 @@ func (a *App) InsertSpecificDummyReviews() ([]Review, error) {
 			"item_id": "aabbccd6-9be8-441f-ad86-d86e5fad7878",
 			"seller": "aaaaaaaa-fa06-4e54-8208-95433b98cfc9",
 			"overall": 2,
-			"post_and_packaging": 8,
-			"communication": 8,
+			"post_and_packaging": 3,
+			"communication": 3,
 			"as_described": 1
 		}
 	]`
@@ -112,6 +128,46 @@ func (a *App) InsertSpecificDummyReviews() ([]Review, error) {
 		return nil, res.Error
 	}
 
+	// reviews created directly don't update the seller totals
+	err = a.RebuildSellerScores()
+	if err != nil {
+		return nil, err
+	}
+
+	return reviews, nil
+}
+
+// InsertDatedDummyReviews creates reviews of one seller at different ages
+// so we can check the effects of weighting scores by age
+func (a *App) InsertDatedDummyReviews() ([]Review, error) {
+
+	seller, _ := uuid.Parse("4a48341f-bcef-4362-9d80-24a4960507ea")
+	now := time.Now()
+	reviews := []Review{
+		{Review: "rubbish", Overall: 1, PapCost: 1, Comm: 1, AsDesc: 1, Created: now.AddDate(-2, 0, 0)},
+		{Review: "great", Overall: 5, PapCost: 5, Comm: 5, AsDesc: 5, Created: now.AddDate(0, 0, -1)},
+		{Review: "brill", Overall: 5, PapCost: 4, Comm: 5, AsDesc: 5, Created: now},
+	}
+	for i := range reviews {
+		reviews[i].ReviewId = uuid.New()
+		reviews[i].ReviewedBy = uuid.New()
+		reviews[i].AuctionId = uuid.New()
+		reviews[i].ItemId = uuid.New()
+		reviews[i].Seller = seller
+	}
+
+	res := a.DB.Create(&reviews)
+	if res.Error != nil {
+		a.Log.Info().Msgf("Reviews creation failed: [%s]", res.Error.Error())
+		return nil, res.Error
+	}
+
+	// reviews created directly don't update the seller totals
+	err := a.RebuildSellerScores()
+	if err != nil {
+		return nil, err
+	}
+
 	return reviews, nil
 }
 
@@ -142,4 +198,68 @@ const createJsonReviewedByIncorrect = `{"auction_id":"f38ba39a-3682-4803-a498-65
 "overall": 4,
 "post_and_packaging": 3,
 "communication": 4,
-"as_described": 4}`
\ No newline at end of file
+"as_described": 4}`
+
+const itemJson = `{"item_id": "f80689a6-9fba-4859-bdde-0a307c696ea8",
+"public_id": "4a48341f-bcef-4362-9d80-24a4960507ea",
+"name": "amazing product"}`
+
+const auctionJson = `{"auction_id": "f38ba39a-3682-4803-a498-659f0b111111",
+"public_id": "4a48341f-bcef-4362-9d80-24a4960507ea",
+"lots": ["f80689a6-9fba-4859-bdde-0a307c696ea8"],
+"status": "finished",
+"winners": [{"item_id": "f80689a6-9fba-4859-bdde-0a307c696ea8", "public_id": "f38ba39a-3682-4803-a498-659f0bf05304"}]}`
+
+const auctionJsonNotFinished = `{"auction_id": "f38ba39a-3682-4803-a498-659f0b111111",
+"public_id": "4a48341f-bcef-4362-9d80-24a4960507ea",
+"lots": ["f80689a6-9fba-4859-bdde-0a307c696ea8"],
+"status": "active",
+"winners": []}`
+
+const auctionJsonItemNotLot = `{"auction_id": "f38ba39a-3682-4803-a498-659f0b111111",
+"public_id": "4a48341f-bcef-4362-9d80-24a4960507ea",
+"lots": ["aabbccd6-9be8-441f-ad86-d86e5fad7878"],
+"status": "finished",
+"winners": [{"item_id": "aabbccd6-9be8-441f-ad86-d86e5fad7878", "public_id": "f38ba39a-3682-4803-a498-659f0bf05304"}]}`
+
+const auctionJsonWrongSeller = `{"auction_id": "f38ba39a-3682-4803-a498-659f0b111111",
+"public_id": "46d7d11c-fa06-4e54-8208-95433b98cfc9",
+"lots": ["f80689a6-9fba-4859-bdde-0a307c696ea8"],
+"status": "finished",
+"winners": [{"item_id": "f80689a6-9fba-4859-bdde-0a307c696ea8", "public_id": "f38ba39a-3682-4803-a498-659f0bf05304"}]}`
+
+const auctionJsonNotWinner = `{"auction_id": "f38ba39a-3682-4803-a498-659f0b111111",
+"public_id": "4a48341f-bcef-4362-9d80-24a4960507ea",
+"lots": ["f80689a6-9fba-4859-bdde-0a307c696ea8"],
+"status": "finished",
+"winners": [{"item_id": "f80689a6-9fba-4859-bdde-0a307c696ea8", "public_id": "f38ba39a-3682-4803-a498-659f0bf05000"}]}`
+
+const createJsonScoreOutOfRange = `{"auction_id":"f38ba39a-3682-4803-a498-659f0b111111",
+"item_id": "f80689a6-9fba-4859-bdde-0a307c696ea8",
+"reviewed_by": "f38ba39a-3682-4803-a498-659f0bf05304",
+"seller": "4a48341f-bcef-4362-9d80-24a4960507ea",
+"review": "amazing product",
+"overall": 9999,
+"post_and_packaging": -1,
+"communication": 4,
+"as_described": 4}`
+
+const createJsonZeroScores = `{"auction_id":"f38ba39a-3682-4803-a498-659f0b111111",
+"item_id": "f80689a6-9fba-4859-bdde-0a307c696ea8",
+"reviewed_by": "f38ba39a-3682-4803-a498-659f0bf05304",
+"seller": "4a48341f-bcef-4362-9d80-24a4960507ea",
+"review": "   never arrived   ",
+"overall": 0,
+"post_and_packaging": 0,
+"communication": 0,
+"as_described": 0}`
+
+const createJsonReviewSelf = `{"auction_id":"f38ba39a-3682-4803-a498-659f0b111111",
+"item_id": "f80689a6-9fba-4859-bdde-0a307c696ea8",
+"reviewed_by": "f38ba39a-3682-4803-a498-659f0bf05304",
+"seller": "f38ba39a-3682-4803-a498-659f0bf05304",
+"review": "amazing product",
+"overall": 5,
+"post_and_packaging": 5,
+"communication": 5,
+"as_described": 5}`
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ----------------------------------------------------------------------------
+// s e r v i c e   a p i   k e y s
+// ----------------------------------------------------------------------------
+
+// scopes that can be given to an api key
+const (
+	scopeReadScores   = "reviews:read"
+	scopeSystemReview = "reviews:write"
+)
+
+var apiKeyScopes = []string{scopeReadScores, scopeSystemReview}
+
+// apiKeyPrefix makes our keys easy to spot if they turn up somewhere
+// they shouldn't
+const apiKeyPrefix = "prk_"
+
+// service is the microservice an api key was issued to
+type service struct {
+	KeyId  string   `json:"key_id"`
+	Name   string   `json:"service"`
+	Scopes []string `json:"scopes"`
+}
+
+// fileApiKey is an entry in the API_KEYS_FILE. like the db the file only
+// holds the sha256 of each key
+type fileApiKey struct {
+	Service string   `json:"service"`
+	KeyHash string   `json:"key_hash"`
+	Scopes  []string `json:"scopes"`
+}
+
+// ----------------------------------------------------------------------------
+
+// InitialiseApiKeys loads any keys in the API_KEYS_FILE. keys issued with
+// the issue-key command live in the db and don't need loading
+func (a *App) InitialiseApiKeys() {
+
+	a.ServiceKeys = map[string]service{}
+	fn := os.Getenv("API_KEYS_FILE")
+	if fn == "" {
+		return
+	}
+	a.Log.Info().Msg("Loading api keys from file")
+	keys, err := loadApiKeysFile(fn)
+	if err != nil {
+		a.Log.Error().Msgf("Unable to load api keys [%s]", err.Error())
+		return
+	}
+	a.ServiceKeys = keys
+}
+
+// ----------------------------------------------------------------------------
+
+func loadApiKeysFile(fn string) (map[string]service, error) {
+
+	data, err := os.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	var fks []fileApiKey
+	if err = json.Unmarshal(data, &fks); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]service, len(fks))
+	for i, fk := range fks {
+		if fk.Service == "" || len(fk.KeyHash) != sha256.Size*2 {
+			return nil, fmt.Errorf("entry %d needs a service and a sha256 key_hash", i)
+		}
+		if err = checkScopes(fk.Scopes); err != nil {
+			return nil, fmt.Errorf("entry %d: %w", i, err)
+		}
+		keys[strings.ToLower(fk.KeyHash)] = service{KeyId: "file", Name: fk.Service, Scopes: fk.Scopes}
+	}
+	return keys, nil
+}
+
+// ----------------------------------------------------------------------------
+
+func checkScopes(scopes []string) error {
+
+	if len(scopes) == 0 {
+		return errors.New("at least one scope is needed")
+	}
+	for _, s := range scopes {
+		if !slices.Contains(apiKeyScopes, s) {
+			return fmt.Errorf("unknown scope [%s]", s)
+		}
+	}
+	return nil
+}
+
+// ----------------------------------------------------------------------------
+
+func hashApiKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// ----------------------------------------------------------------------------
+
+// lookupApiKey returns the service a key belongs to. keys from the file are
+// checked before the db
+func (a *App) lookupApiKey(key string) (service, bool, error) {
+
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return service{}, false, nil
+	}
+	h := hashApiKey(key)
+	if svc, ok := a.ServiceKeys[h]; ok {
+		return svc, true, nil
+	}
+
+	var ak ApiKey
+	res := a.DB.Where("key_hash = ? AND revoked IS NULL", h).Limit(1).Find(&ak)
+	if res.Error != nil {
+		return service{}, false, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return service{}, false, nil
+	}
+	return ak.service(), true, nil
+}
+
+// ----------------------------------------------------------------------------
+
+func (ak *ApiKey) service() service {
+	return service{KeyId: ak.KeyId.String(), Name: ak.Service, Scopes: strings.Fields(ak.Scopes)}
+}
+
+// ----------------------------------------------------------------------------
+
+func (s service) allows(scope string) bool {
+	return slices.Contains(s.Scopes, scope)
+}
+
+// ----------------------------------------------------------------------------
+
+// IssueApiKey makes a new key for a service and returns it. the key itself
+// is only ever seen here, we just store its hash
+func (a *App) IssueApiKey(name string, scopes []string) (ApiKey, string, error) {
+
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 50 {
+		return ApiKey{}, "", errors.New("service name must be 1 to 50 characters")
+	}
+	if err := checkScopes(scopes); err != nil {
+		return ApiKey{}, "", err
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return ApiKey{}, "", err
+	}
+	key := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(b)
+
+	keyId, _ := uuid.NewRandom()
+	ak := ApiKey{
+		KeyId:   keyId,
+		Service: name,
+		KeyHash: hashApiKey(key),
+		Scopes:  strings.Join(scopes, " "),
+	}
+	if err := a.DB.Create(&ak).Error; err != nil {
+		return ApiKey{}, "", err
+	}
+	return ak, key, nil
+}
+
+// ----------------------------------------------------------------------------
+
+// RevokeApiKey stops a key from working. revoked keys are kept so we know
+// who they belonged to
+func (a *App) RevokeApiKey(keyId uuid.UUID) error {
+
+	res := a.DB.Model(&ApiKey{}).
+		Where("key_id = ? AND revoked IS NULL", keyId).
+		Update("revoked", time.Now())
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return errors.New("no active key with that id")
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/mux"
@@ -10,20 +11,45 @@ import (
 )
 
 type App struct {
-	ORouter *mux.Router
-	ODB     *sql.DB
-	Router  *gin.Engine
-	DB      *gorm.DB
-	Log     *zerolog.Logger
+	ORouter     *mux.Router
+	ODB         *sql.DB
+	Router      *gin.Engine
+	DB          *gorm.DB
+	Log         *zerolog.Logger
+	JWT         *jwtVerifier
+	TokenCache  *tokenCache
+	ServiceKeys map[string]service
+	RateLimiter *rateLimiter
+	HTTPCache   *httpCache
+	stop        context.CancelFunc
 }
 
 func (a *App) InitialiseApp() {
+	// anything running in the background is stopped by Stop
+	var ctx context.Context
+	ctx, a.stop = context.WithCancel(context.Background())
+
 	a.Router = gin.Default()
+	a.InitialiseValidators()
+	a.InitialiseJWT(ctx)
+	a.InitialiseTokenCache()
+	a.InitialiseApiKeys()
+	a.InitialiseRateLimiter()
+	a.InitialiseHTTPCache()
+	a.InitialisePagination()
 	a.InitialiseRoutes()
 	a.InitialiseDatabase()
 }
 
 func (a *App) Run(port string) {
+	defer a.Stop()
 	a.Log.Info().Msgf("Server running on port [%s]", port)
 	a.Log.Fatal().Err(a.Router.Run(port))
 }
+
+// Stop ends anything InitialiseApp started in the background
+func (a *App) Stop() {
+	if a.stop != nil {
+		a.stop()
+	}
+}
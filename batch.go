@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"net/http"
+)
+
+// ----------------------------------------------------------------------------
+// b a t c h   l o o k u p s
+// ----------------------------------------------------------------------------
+
+// batchColumns maps what a batch can look reviews up by to the column
+var batchColumns = map[string]string{
+	"review":  "review_id",
+	"auction": "auction_id",
+	"item":    "item_id",
+}
+
+// defaultBatchMaxIds is used if BATCH_MAX_IDS isn't set
+const defaultBatchMaxIds = 50
+
+// batchResult is what's returned for each id in a batch. ids that can't be
+// found or aren't valid get a status and message instead of failing the
+// whole batch
+type batchResult struct {
+	Status  int      `json:"status"`
+	Message string   `json:"message,omitempty"`
+	Reviews []Review `json:"reviews,omitempty"`
+}
+
+// ----------------------------------------------------------------------------
+
+func (a *App) getBatch(c *gin.Context) {
+	a.batchReviews(c, c.DefaultQuery("by", "review"), c.QueryArray("id"))
+}
+
+// ----------------------------------------------------------------------------
+
+func (a *App) postBatch(c *gin.Context) {
+
+	var bi BatchInput
+	if err := c.ShouldBindJSON(&bi); err != nil {
+		a.Log.Info().Msgf("Input data does not match batch: [%s]", err.Error())
+		badInput(c, err)
+		return
+	}
+	if bi.By == "" {
+		bi.By = "review"
+	}
+	a.batchReviews(c, bi.By, bi.Ids)
+}
+
+// ----------------------------------------------------------------------------
+
+// batchReviews fetches the reviews for a list of ids in one query and
+// returns them keyed by the ids as they were sent
+func (a *App) batchReviews(c *gin.Context, by string, ids []string) {
+
+	col, ok := batchColumns[by]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Not a valid by value"})
+		return
+	}
+	maxIds := envInt("BATCH_MAX_IDS", defaultBatchMaxIds)
+	if len(ids) == 0 || len(ids) > maxIds {
+		c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("A batch must have between 1 and %d ids", maxIds)})
+		return
+	}
+
+	results := make(map[string]batchResult, len(ids))
+	sent := make(map[uuid.UUID]string, len(ids))
+	var valid []uuid.UUID
+	for _, s := range ids {
+		id, err := uuid.Parse(s)
+		if err != nil {
+			results[s] = batchResult{Status: http.StatusBadRequest, Message: "Not a uuid string"}
+			continue
+		}
+		if _, ok := sent[id]; !ok {
+			sent[id] = s
+			valid = append(valid, id)
+		}
+	}
+
+	var reviews []Review
+	if len(valid) > 0 {
+		err := a.DB.Scopes(notHidden).Where(col+" IN ?", valid).Order("created desc").Find(&reviews).Error
+		if err != nil {
+			a.Log.Info().Msgf("Error fetching batch: [%s]", err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "Something went bang"})
+			return
+		}
+	}
+	if len(reviews) > 0 {
+		if err := a.attachReplies(reviews); err != nil {
+			a.Log.Info().Msgf("Error fetching replies: [%s]", err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "Something went bang"})
+			return
+		}
+	}
+
+	if len(valid) > 0 {
+		a.setLastModified(c, col+" IN ?", valid)
+	}
+
+	byId := make(map[uuid.UUID][]Review, len(valid))
+	for _, rv := range reviews {
+		k := batchKey(&rv, col)
+		byId[k] = append(byId[k], rv)
+	}
+	found := 0
+	for _, id := range valid {
+		if rvs, ok := byId[id]; ok {
+			results[sent[id]] = batchResult{Status: http.StatusOK, Reviews: rvs}
+			found++
+			continue
+		}
+		results[sent[id]] = batchResult{Status: http.StatusNotFound, Message: "No reviews found"}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"by": by, "found": found, "results": results})
+}
+
+// ----------------------------------------------------------------------------
+
+func batchKey(rv *Review, col string) uuid.UUID {
+	switch col {
+	case "auction_id":
+		return rv.AuctionId
+	case "item_id":
+		return rv.ItemId
+	}
+	return rv.ReviewId
+}
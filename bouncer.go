@@ -5,55 +5,107 @@ import (
 	"github.com/gin-gonic/gin"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 )
 
 type user struct {
-	PublicId string `json:"public_id"`
+	PublicId string   `json:"public_id"`
+	Roles    []string `json:"roles"`
+	Scopes   []string `json:"scopes"`
 }
 
-func (a *App) bouncerSaysOk(c *gin.Context) (bool, int, string) {
+// defaultAdminRoles is used if ADMIN_ROLES isn't set
+const defaultAdminRoles = "admin"
+
+// authyClient is shared so connections to authy get reused
+var authyClient = &http.Client{Timeout: time.Second * 10}
+
+// bouncerSaysOk checks the access token and returns the user it belongs to.
+// it's normally called by the authRequired middleware rather than directly
+func (a *App) bouncerSaysOk(c *gin.Context) (bool, int, string, user) {
 
-	ct := c.GetHeader("Content-type")
 	bm := "Ooh you are naughty"
+	x := c.GetHeader("X-Access-Token")
 
-	if !(ct == "application/json" ||
-		ct == "application/json; charset=UTF-8") {
-		return false, http.StatusBadRequest, "Request must be json"
+	if x == "" {
+		a.Log.Info().Msg("No x-access-token found")
+		return false, http.StatusUnauthorized, bm, user{}
 	}
 
-	x := c.GetHeader("X-Access-Token")
+	// check the token ourselves if we can and only ask authy if allowed to
+	if a.JWT != nil {
+		u, err := a.JWT.verify(x)
+		if err == nil {
+			return true, http.StatusOK, u.PublicId, u
+		}
+		a.Log.Info().Msgf("Token failed local verification [%s]", err.Error())
+		if !authyFallback() {
+			return false, http.StatusUnauthorized, bm, user{}
+		}
+	}
 
-	if x != "" {
+	if a.TokenCache != nil {
+		return a.TokenCache.verify(x, a.verifyWithAuthy)
+	}
+	return a.verifyWithAuthy(x)
+}
+
+// ----------------------------------------------------------------------------
+
+func (a *App) verifyWithAuthy(x string) (bool, int, string, user) {
+
+	bm := "Ooh you are naughty"
 
-		// call authy microservice
-		req, err := http.NewRequest("GET", os.Getenv("AUTHYURL"), nil)
-		if err != nil {
-			a.Log.Info().Msgf("Error is [%s]", err.Error())
-			return false, http.StatusUnauthorized, bm
+	// call authy microservice
+	req, err := http.NewRequest("GET", os.Getenv("AUTHYURL"), nil)
+	if err != nil {
+		a.Log.Info().Msgf("Error is [%s]", err.Error())
+		return false, http.StatusUnauthorized, bm, user{}
+	}
+
+	req.Header.Set("X-Access-Token", x)
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+
+	resp, err := authyClient.Do(req)
+	if err != nil {
+		a.Log.Info().Msgf("HTTP req failed with [%s]", err.Error())
+		return false, http.StatusServiceUnavailable, "I'm sorry Dave", user{}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == 200 {
+		var u user
+		if err := json.NewDecoder(resp.Body).Decode(&u); err != nil {
+			a.Log.Info().Msgf("Error deserializing JSON [%s]", err.Error())
+			return false, http.StatusBadRequest, "Unable to decode response body", user{}
 		}
+		return true, http.StatusOK, u.PublicId, u
+	}
+	a.Log.Info().Msgf("Authy returned status [%d]", resp.StatusCode)
+
+	return false, http.StatusUnauthorized, bm, user{}
+}
+
+// ----------------------------------------------------------------------------
+
+// isAdmin checks if any of the user's roles or scopes are in the comma
+// separated list of ADMIN_ROLES
+func (u user) isAdmin() bool {
 
-		req.Header.Set("X-Access-Token", x)
-		req.Header.Set("Content-Type", "application/json; charset=UTF-8")
-
-		client := &http.Client{Timeout: time.Second * 10}
-		resp, e := client.Do(req)
-		if e != nil {
-			a.Log.Info().Msgf("HTTP req failed with [%s]", err.Error())
-			return false, http.StatusServiceUnavailable, "I'm sorry Dave"
-		} else {
-			defer resp.Body.Close()
-			if resp.StatusCode == 200 {
-				var u user
-				if err := json.NewDecoder(resp.Body).Decode(&u); err != nil {
-					a.Log.Info().Msgf("Error deserializing JSON [%s]", err.Error())
-					return false, http.StatusBadRequest, "Unable to decode response body"
-				}
-				return true, http.StatusOK, u.PublicId
+	roles := os.Getenv("ADMIN_ROLES")
+	if roles == "" {
+		roles = defaultAdminRoles
+	}
+	for _, ar := range strings.Split(roles, ",") {
+		ar = strings.TrimSpace(ar)
+		if ar == "" {
+			continue
+		}
+		for _, r := range append(u.Roles, u.Scopes...) {
+			if strings.EqualFold(r, ar) {
+				return true
 			}
 		}
 	}
-	a.Log.Info().Msg("No x-access-token found")
-
-	return false, http.StatusUnauthorized, bm
+	return false
 }
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"strings"
+)
+
+// ----------------------------------------------------------------------------
+// c o m m a n d   l i n e
+// ----------------------------------------------------------------------------
+
+const commandUsage = `usage: reviews [command]
+
+With no command the reviews server is started.
+
+Commands:
+  rebuild-scores   recalculate the seller_scores and score_counts tables
+                   from the reviews table
+  verify-scores    check the seller_scores and score_counts tables match
+                   the reviews table
+  issue-key <service> <scope>...
+                   make an api key for another microservice. scopes are
+                   reviews:read and reviews:write
+  revoke-key <key_id>
+                   stop an api key from working
+  list-keys        list the api keys in the db
+`
+
+// RunCommand runs a maintenance command and returns the exit code
+func (a *App) RunCommand(args []string) int {
+
+	switch args[0] {
+	case "rebuild-scores":
+		if err := a.RebuildSellerScores(); err != nil {
+			fmt.Printf("Failed to rebuild seller scores [%s]\n", err.Error())
+			return 1
+		}
+		fmt.Println("Seller scores rebuilt")
+		return 0
+
+	case "verify-scores":
+		mismatches, err := a.VerifySellerScores()
+		if err != nil {
+			fmt.Printf("Failed to verify seller scores [%s]\n", err.Error())
+			return 1
+		}
+		for _, m := range mismatches {
+			fmt.Printf("Seller [%s] live %+v written %d stored %+v written %d distribution differs %t\n",
+				m.Seller, m.Live, m.LiveWritten, m.Stored, m.StoredWritten, m.Distribution)
+		}
+		if len(mismatches) > 0 {
+			fmt.Printf("%d seller(s) don't match - run rebuild-scores to fix\n", len(mismatches))
+			return 1
+		}
+		fmt.Println("Seller scores match reviews")
+		return 0
+
+	case "issue-key":
+		if len(args) < 3 {
+			break
+		}
+		ak, key, err := a.IssueApiKey(args[1], args[2:])
+		if err != nil {
+			fmt.Printf("Failed to issue api key [%s]\n", err.Error())
+			return 1
+		}
+		fmt.Printf("Issued key [%s] to service [%s] with scopes [%s]\n", ak.KeyId, ak.Service, ak.Scopes)
+		fmt.Printf("%s\n", key)
+		fmt.Println("The key is not stored so keep it safe, it can't be shown again")
+		return 0
+
+	case "revoke-key":
+		if len(args) != 2 {
+			break
+		}
+		keyId, err := uuid.Parse(args[1])
+		if err == nil {
+			err = a.RevokeApiKey(keyId)
+		}
+		if err != nil {
+			fmt.Printf("Failed to revoke api key [%s]\n", err.Error())
+			return 1
+		}
+		fmt.Printf("Revoked key [%s]\n", keyId)
+		return 0
+
+	case "list-keys":
+		var keys []ApiKey
+		if err := a.DB.Order("created").Find(&keys).Error; err != nil {
+			fmt.Printf("Failed to list api keys [%s]\n", err.Error())
+			return 1
+		}
+		for _, k := range keys {
+			status := "active"
+			if k.Revoked != nil {
+				status = "revoked " + k.Revoked.Format("2006-01-02 15:04")
+			}
+			fmt.Printf("%s  %-20s  %-28s  %s\n", k.KeyId, k.Service, strings.ReplaceAll(k.Scopes, " ", ","), status)
+		}
+		return 0
+	}
+
+	fmt.Print(commandUsage)
+	return 2
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+)
+
+// ----------------------------------------------------------------------------
+// c u r s o r   p a g i n a t i o n
+// ----------------------------------------------------------------------------
+
+// cursor directions
+const (
+	cursorNext = "next"
+	cursorPrev = "prev"
+)
+
+var errBadCursor = errors.New("cursor is not valid")
+
+// reviewCursor marks a position in a list of reviews. reviews are ordered
+// by created and then review_id so that reviews created at the same time
+// still have a fixed order. clients only ever see it base64 encoded. an
+// inclusive cursor's page starts with the review it points at
+type reviewCursor struct {
+	Created   time.Time `json:"c"`
+	ReviewId  uuid.UUID `json:"r"`
+	Dir       string    `json:"d"`
+	Sort      string    `json:"s"`
+	Inclusive bool      `json:"i,omitempty"`
+}
+
+// ----------------------------------------------------------------------------
+
+func (rc reviewCursor) encode() string {
+	b, _ := json.Marshal(rc)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// ----------------------------------------------------------------------------
+
+func decodeCursor(s, sort string) (*reviewCursor, error) {
+
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errBadCursor
+	}
+	var rc reviewCursor
+	if err = json.Unmarshal(b, &rc); err != nil {
+		return nil, errBadCursor
+	}
+	if (rc.Dir != cursorNext && rc.Dir != cursorPrev) || rc.Sort != sort || rc.ReviewId == uuid.Nil {
+		return nil, errBadCursor
+	}
+	return &rc, nil
+}
+
+// ----------------------------------------------------------------------------
+
+func cursorAt(rv *Review, dir, sort string) string {
+	return reviewCursor{Created: rv.Created, ReviewId: rv.ReviewId, Dir: dir, Sort: sort}.encode()
+}
+
+// ----------------------------------------------------------------------------
+
+// Keyset returns one more review than the page size after the cursor so we
+// know if there's another page. going backwards the order is flipped and
+// the reviews need reversing afterwards
+func Keyset(rc *reviewCursor, sort string, pagesize int) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+
+		dir := sort
+		if rc != nil && rc.Dir == cursorPrev {
+			if sort == "asc" {
+				dir = "desc"
+			} else {
+				dir = "asc"
+			}
+		}
+		if rc != nil {
+			cmp := ">"
+			if dir == "desc" {
+				cmp = "<"
+			}
+			if rc.Inclusive {
+				cmp += "="
+			}
+			db = db.Where("(created, review_id) "+cmp+" (?, ?)", rc.Created, rc.ReviewId)
+		}
+		return db.Order("created " + dir).Order("review_id " + dir).Limit(pagesize + 1)
+	}
+}
+
+// ----------------------------------------------------------------------------
+
+// fetchReviewsByCursor is used instead of page numbers if a cursor is passed
+// in. an empty cursor starts at the beginning. there is no count so it stays
+// fast however deep the client goes and new reviews don't shift the pages
+func (a *App) fetchReviewsByCursor(c *gin.Context, db *gorm.DB, rk string, id uuid.UUID, sort string, pagesize int, lo listOptions) {
+
+	var rc *reviewCursor
+	if cs := c.Query("cursor"); cs != "" {
+		var err error
+		if rc, err = decodeCursor(cs, sort); err != nil {
+			a.Log.Info().Msgf("Bad cursor [%s]", cs)
+			c.JSON(http.StatusBadRequest, gin.H{"message": "Not a valid cursor"})
+			return
+		}
+	}
+
+	var reviews []Review
+	err := db.Model(&Review{}).Where(rk+" = ?", id).Scopes(Keyset(rc, sort, pagesize), lo.Select).Find(&reviews).Error
+	if err != nil {
+		a.Log.Info().Msgf("Error fetching data: [%s]", err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Bad request"})
+		return
+	}
+
+	more := len(reviews) > pagesize
+	if more {
+		reviews = reviews[:pagesize]
+	}
+	backwards := rc != nil && rc.Dir == cursorPrev
+	if backwards {
+		slices.Reverse(reviews)
+	}
+	if len(reviews) == 0 && rc == nil {
+		c.JSON(http.StatusNotFound, gin.H{"total_reviews": 0})
+		return
+	}
+
+	var next, prev string
+	if len(reviews) == 0 {
+		// the cursor has gone past the end, or before the start going
+		// backwards, e.g. if reviews were deleted. link back to the page
+		// that starts with the review the cursor points at
+		back := reviewCursor{Created: rc.Created, ReviewId: rc.ReviewId, Sort: sort, Inclusive: true}
+		if backwards {
+			back.Dir = cursorNext
+			next = back.encode()
+		} else {
+			back.Dir = cursorPrev
+			prev = back.encode()
+		}
+	} else {
+		// going forwards there's a previous page if we came from one and
+		// going backwards there's always a next page
+		if more || backwards {
+			next = cursorAt(&reviews[len(reviews)-1], cursorNext, sort)
+		}
+		if (backwards && more) || (!backwards && rc != nil) {
+			prev = cursorAt(&reviews[0], cursorPrev, sort)
+		}
+	}
+
+	links := Links{First: cursorURL(c, "")}
+	var urls []URL
+	if prev != "" {
+		links.Prev = cursorURL(c, prev)
+		urls = append(urls, URL{PrevURL: links.Prev, PrevCursor: prev})
+	}
+	if next != "" {
+		links.Next = cursorURL(c, next)
+		urls = append(urls, URL{NextURL: links.Next, NextCursor: next})
+	}
+	setLinkHeader(c, links)
+	resp := gin.H{"links": links}
+	if prev != "" {
+		resp["prev_cursor"] = prev
+	}
+	if next != "" {
+		resp["next_cursor"] = next
+	}
+	if len(urls) > 0 {
+		resp["urls"] = urls
+	}
+	if err = a.addReviews(resp, reviews, lo); err != nil {
+		a.Log.Info().Msgf("Error adding reviews to response: [%s]", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Something went bang"})
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// ----------------------------------------------------------------------------
+
+// cursorURL keeps the rest of the query string so the next page has the
+// same sort and page size. an empty cursor is the first page
+func cursorURL(c *gin.Context, cursor string) string {
+
+	q := c.Request.URL.Query()
+	q.Del("page")
+	q.Set("cursor", cursor)
+	return listURL(c, q)
+}
+
+// ----------------------------------------------------------------------------
+
+// wantsCursor is true if the client has asked for cursor pagination, even
+// with an empty cursor
+func wantsCursor(q url.Values) bool {
+	_, ok := q["cursor"]
+	return ok
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"github.com/google/uuid"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"os"
@@ -45,7 +46,8 @@ func ConnectToDB() (*gorm.DB, error) {
 		os.Getenv("DB_NAME"),
 		os.Getenv("DB_HOST"),
 		os.Getenv("DB_PORT"))
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	// translate errors so we can spot unique constraint violations
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{TranslateError: true})
 	if err != nil {
 		return nil, err
 	}
@@ -55,9 +57,65 @@ func ConnectToDB() (*gorm.DB, error) {
 func (a *App) MigrateModels() {
 
 	a.Log.Info().Msg("Migrating models")
-	err := a.DB.AutoMigrate(&Review{})
+	// if seller scores is new we have to fill it from existing reviews
+	newScores := !a.DB.Migrator().HasTable(&SellerScore{}) || !a.DB.Migrator().HasTable(&ScoreCount{})
+
+	// reviews has a unique index on reviewer, auction and item which can't
+	// be made while there are duplicate reviews so the extras go first
+	var removed int
+	var err error
+	if a.DB.Migrator().HasTable(&Review{}) && !a.DB.Migrator().HasIndex(&Review{}, "idx_reviewer_auction_item") {
+		if removed, err = a.RemoveDuplicateReviews(); err != nil {
+			a.Log.Fatal().Msg(err.Error())
+		}
+	}
+
+	err = a.DB.AutoMigrate(&Review{}, &SellerScore{}, &ScoreCount{}, &ReviewReply{}, &ReviewRevision{}, &AdminAction{}, &ApiKey{})
 	if err != nil {
 		a.Log.Fatal().Msg(err.Error())
 	}
+	if err = a.CreateSearchIndex(); err != nil {
+		a.Log.Fatal().Msg(err.Error())
+	}
+	if newScores || removed > 0 {
+		if err = a.RebuildSellerScores(); err != nil {
+			a.Log.Fatal().Msg(err.Error())
+		}
+	}
 	a.Log.Info().Msg("Models migrated successfully")
-}
\ No newline at end of file
+}
+// RemoveDuplicateReviews deletes all but one review for each reviewer,
+// auction and item. it keeps the earliest review that hasn't been deleted
+// and returns how many were removed. the duplicates are removed for good as
+// the unique index counts soft deleted reviews too
+func (a *App) RemoveDuplicateReviews() (int, error) {
+
+	var ids []uuid.UUID
+	err := a.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Raw("DELETE FROM reviews WHERE review_id IN (" +
+			"SELECT review_id FROM (" +
+			"SELECT review_id, ROW_NUMBER() OVER (PARTITION BY reviewed_by, auction_id, item_id " +
+			"ORDER BY deleted_at IS NOT NULL, created, review_id) as rn FROM reviews) as d " +
+			"WHERE rn > 1) RETURNING review_id").Scan(&ids).Error
+		if err != nil || len(ids) == 0 {
+			return err
+		}
+		// replies and revisions of the removed reviews would be orphans
+		for _, m := range []interface{}{&ReviewReply{}, &ReviewRevision{}} {
+			if !tx.Migrator().HasTable(m) {
+				continue
+			}
+			if err = tx.Where("review_id IN ?", ids).Delete(m).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if len(ids) > 0 {
+		a.Log.Info().Msgf("Removed [%d] duplicate reviews", len(ids))
+	}
+	return len(ids), nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"slices"
+	"strings"
+)
+
+// ----------------------------------------------------------------------------
+// s p a r s e   f i e l d s e t s   a n d   e m b e d s
+// ----------------------------------------------------------------------------
+
+// reviewFieldColumns maps the json name of each review field a client can
+// ask for to its column. reply comes from its own table so has no column
+var reviewFieldColumns = map[string]string{
+	"review_id":          "review_id",
+	"review":             "review",
+	"reviewed_by":        "reviewed_by",
+	"auction_id":         "auction_id",
+	"item_id":            "item_id",
+	"seller":             "seller",
+	"overall":            "overall",
+	"post_and_packaging": "pap_cost",
+	"communication":      "comm",
+	"as_described":       "as_desc",
+	"created":            "created",
+	"deleted_at":         "deleted_at",
+	"deleted_reason":     "deleted_reason",
+	"deleted_by":         "deleted_by",
+	"hidden":             "hidden",
+	"hidden_reason":      "hidden_reason",
+	"hidden_by":          "hidden_by",
+	"edited":             "edited",
+	"edited_at":          "edited_at",
+	"posted_by":          "posted_by",
+	"reply":              "",
+}
+
+const embedSellerScores = "seller_scores"
+
+// listOptions are the fields and embeds asked for in a list of reviews. a
+// nil Fields means every field
+type listOptions struct {
+	Fields            []string
+	EmbedSellerScores bool
+}
+
+// ----------------------------------------------------------------------------
+
+// parseListOptions checks the fields and embed query strings. review_id is
+// always returned so clients can still tell the reviews apart
+func parseListOptions(c *gin.Context) (listOptions, error) {
+
+	var lo listOptions
+	if s, ok := c.GetQuery("fields"); ok {
+		lo.Fields = []string{"review_id"}
+		for _, f := range strings.Split(s, ",") {
+			f = strings.TrimSpace(f)
+			if _, ok := reviewFieldColumns[f]; !ok {
+				return lo, errors.New("Not a valid fields value")
+			}
+			if !slices.Contains(lo.Fields, f) {
+				lo.Fields = append(lo.Fields, f)
+			}
+		}
+	}
+	if s, ok := c.GetQuery("embed"); ok {
+		for _, e := range strings.Split(s, ",") {
+			if strings.TrimSpace(e) != embedSellerScores {
+				return lo, errors.New("Not a valid embed value")
+			}
+			lo.EmbedSellerScores = true
+		}
+	}
+	return lo, nil
+}
+
+// ----------------------------------------------------------------------------
+
+func (lo listOptions) wants(field string) bool {
+	return lo.Fields == nil || slices.Contains(lo.Fields, field)
+}
+
+// ----------------------------------------------------------------------------
+
+// Select only fetches the columns that are needed. created is always fetched
+// for cursors and seller is fetched if the seller scores are to be embedded
+func (lo listOptions) Select(db *gorm.DB) *gorm.DB {
+
+	if lo.Fields == nil {
+		return db
+	}
+	cols := []string{"review_id", "created"}
+	if lo.EmbedSellerScores {
+		cols = append(cols, "seller")
+	}
+	for _, f := range lo.Fields {
+		if col := reviewFieldColumns[f]; col != "" && !slices.Contains(cols, col) {
+			cols = append(cols, col)
+		}
+	}
+	return db.Select(cols)
+}
+
+// ----------------------------------------------------------------------------
+
+// addReviews puts the reviews into the response along with anything that
+// was asked to be embedded
+func (a *App) addReviews(resp gin.H, reviews []Review, lo listOptions) error {
+
+	if len(reviews) > 0 && lo.wants("reply") {
+		if err := a.attachReplies(reviews); err != nil {
+			return err
+		}
+	}
+
+	if lo.EmbedSellerScores {
+		var sellers []uuid.UUID
+		for _, rv := range reviews {
+			if !slices.Contains(sellers, rv.Seller) {
+				sellers = append(sellers, rv.Seller)
+			}
+		}
+		scores, err := a.GetSellersScores(sellers)
+		if err != nil {
+			return err
+		}
+		resp["seller_scores"] = scores
+	}
+
+	if lo.Fields == nil {
+		resp["reviews"] = reviews
+		return nil
+	}
+	sparse := make([]map[string]json.RawMessage, 0, len(reviews))
+	for _, rv := range reviews {
+		b, err := json.Marshal(rv)
+		if err != nil {
+			return err
+		}
+		var all map[string]json.RawMessage
+		if err = json.Unmarshal(b, &all); err != nil {
+			return err
+		}
+		m := make(map[string]json.RawMessage, len(lo.Fields))
+		for _, f := range lo.Fields {
+			if v, ok := all[f]; ok {
+				m[f] = v
+			}
+		}
+		sparse = append(sparse, m)
+	}
+	resp["reviews"] = sparse
+	return nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"strconv"
+	"time"
+)
+
+// ----------------------------------------------------------------------------
+// l i s t   o r d e r i n g   a n d   f i l t e r s
+// ----------------------------------------------------------------------------
+
+// orderByColumns is the allow-list of orderby values and what they order by
+var orderByColumns = map[string]string{
+	"created":            "created",
+	"overall":            "overall",
+	"post_and_packaging": "pap_cost",
+	"communication":      "comm",
+	"as_described":       "as_desc",
+}
+
+// reviewFilters are the optional filters for lists of reviews. nil means
+// the filter wasn't asked for
+type reviewFilters struct {
+	MinOverall    *int
+	MaxOverall    *int
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	HasText       *bool
+}
+
+// ----------------------------------------------------------------------------
+
+// orderClause turns orderby and sort into an order by clause. created and
+// then review_id break any ties, in the same direction, so pages stay in the
+// same order
+func orderClause(orderby, sort string) (string, error) {
+
+	col, ok := orderByColumns[orderby]
+	if !ok {
+		return "", errors.New("Not a valid orderby value")
+	}
+	if sort != "asc" && sort != "desc" {
+		return "", errors.New("Not a valid sort value")
+	}
+	if orderby == "created" {
+		return col + " " + sort + ", review_id " + sort, nil
+	}
+	return col + " " + sort + ", created " + sort + ", review_id " + sort, nil
+}
+
+// ----------------------------------------------------------------------------
+
+// parseReviewFilters checks the filter query strings. the error message is
+// returned to the client
+func parseReviewFilters(c *gin.Context) (reviewFilters, error) {
+
+	var rf reviewFilters
+	var err error
+	if rf.MinOverall, err = scoreQuery(c, "min_overall"); err != nil {
+		return rf, err
+	}
+	if rf.MaxOverall, err = scoreQuery(c, "max_overall"); err != nil {
+		return rf, err
+	}
+	if rf.MinOverall != nil && rf.MaxOverall != nil && *rf.MinOverall > *rf.MaxOverall {
+		return rf, errors.New("min_overall can't be more than max_overall")
+	}
+	if rf.CreatedAfter, err = timeQuery(c, "created_after"); err != nil {
+		return rf, err
+	}
+	if rf.CreatedBefore, err = timeQuery(c, "created_before"); err != nil {
+		return rf, err
+	}
+	if s, ok := c.GetQuery("has_text"); ok {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return rf, errors.New("Not a valid has_text value")
+		}
+		rf.HasText = &b
+	}
+	return rf, nil
+}
+
+// ----------------------------------------------------------------------------
+
+func scoreQuery(c *gin.Context, name string) (*int, error) {
+
+	s, ok := c.GetQuery(name)
+	if !ok {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < minScore || n > maxScore {
+		return nil, errors.New("Not a valid " + name + " value")
+	}
+	return &n, nil
+}
+
+// ----------------------------------------------------------------------------
+
+// timeQuery takes either a full RFC3339 time or just a date
+func timeQuery(c *gin.Context, name string) (*time.Time, error) {
+
+	s, ok := c.GetQuery(name)
+	if !ok {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		t, err = time.Parse(time.DateOnly, s)
+	}
+	if err != nil {
+		return nil, errors.New("Not a valid " + name + " value")
+	}
+	return &t, nil
+}
+
+// ----------------------------------------------------------------------------
+
+// Filter adds the filters to a query of reviews
+func (rf reviewFilters) Filter(db *gorm.DB) *gorm.DB {
+
+	if rf.MinOverall != nil {
+		db = db.Where("overall >= ?", *rf.MinOverall)
+	}
+	if rf.MaxOverall != nil {
+		db = db.Where("overall <= ?", *rf.MaxOverall)
+	}
+	if rf.CreatedAfter != nil {
+		db = db.Where("created > ?", *rf.CreatedAfter)
+	}
+	if rf.CreatedBefore != nil {
+		db = db.Where("created < ?", *rf.CreatedBefore)
+	}
+	if rf.HasText != nil {
+		if *rf.HasText {
+			db = db.Where("review <> ''")
+		} else {
+			db = db.Where("(review = '' OR review IS NULL)")
+		}
+	}
+	return db
+}
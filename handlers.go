@@ -2,35 +2,41 @@ package main
 
 import (
 	"database/sql"
-	"encoding/json"
+	"errors"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"net/http"
 	"os"
-	"strconv"
+	"strings"
+	"time"
 )
 
+// errNoReview is returned from transactions when the review to be
+// changed can't be found
+var errNoReview = errors.New("no review found")
+
+// errEditWindowClosed is returned when a review is too old to be edited
+var errEditWindowClosed = errors.New("edit window has closed")
+
 // ----------------------------------------------------------------------------
 
 func (a *App) createReview(c *gin.Context) {
 
 	a.Log.Debug().Msg("In createReview")
 
-	b, st, mess := a.bouncerSaysOk(c)
-	if !b {
-		c.JSON(st, gin.H{"message": mess})
-		return
-	}
-	publicId := mess
+	publicId := currentPublicId(c)
 	xhdr := c.GetHeader("X-Access-Token")
 	a.Log.Debug().Msgf("Public Id is [%s]", publicId)
-	var rv Review
+	var ri ReviewInput
 	var err error
-	if err = c.ShouldBindJSON(&rv); err != nil {
+	if err = c.ShouldBindJSON(&ri); err != nil {
 		a.Log.Info().Msgf("Input data does not match review: [%s]", err.Error())
-		c.JSON(http.StatusBadRequest, gin.H{"message": "Input data is incorrect"})
+		badInput(c, err)
 		return
 	}
+	rv := ri.toReview()
 
 	if rv.ReviewedBy.String() != publicId {
 		a.Log.Info().Msg("Supplied reviewedBy id does not match publicId")
@@ -38,42 +44,102 @@ func (a *App) createReview(c *gin.Context) {
 		return
 	}
 
-	// check auction id and item id's here
-	//var item Item
-	//var auction Auction
-	var itemAll map[string]any
-	var auctionAll map[string]any
+	// a user can only leave one review per auction item
+	var existingId uuid.UUID
+	existingId, err = a.existingReviewId(&rv)
+	if err != nil {
+		a.Log.Info().Msgf("Error checking for existing review [%s]", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Something went bang."})
+		return
+	}
+	if existingId != uuid.Nil {
+		c.JSON(http.StatusConflict, gin.H{"message": "Review already exists", "review_id": existingId})
+		return
+	}
+
+	if !a.checkReviewedAuction(c, &rv, xhdr) {
+		return
+	}
+
+	a.saveNewReview(c, &rv)
+}
+
+// ----------------------------------------------------------------------------
+
+// checkReviewedAuction fetches the item and auction being reviewed and checks
+// the reviewer won the item from the seller. token is passed on to the item
+// and auction services. if it returns false the response has been sent
+func (a *App) checkReviewedAuction(c *gin.Context, rv *Review, token string) bool {
+
+	var item Item
+	var auction Auction
 	requests := []HTTPRequest{
 		{
 			URL:     os.Getenv("ITEMURL")+rv.ItemId.String(),
-			Headers: map[string]string{"x-access-token": xhdr,
-				"Content-Type": "application/json"},
-			Result:  &itemAll,
+			Headers: map[string]string{"x-access-token": token,
+				"Content-Type": "application/json",
+				requestIdHeader: currentRequestId(c)},
+			Result:  &item,
 		},
 		{
 			URL:     os.Getenv("AUCTIONURL")+rv.AuctionId.String(),
-			Headers: map[string]string{"x-access-token": xhdr,
-				"Content-Type": "application/json"},
-			Result:  &auctionAll,
+			Headers: map[string]string{"x-access-token": token,
+				"Content-Type": "application/json",
+				requestIdHeader: currentRequestId(c)},
+			Result:  &auction,
 		},
 	}
 
 	results := a.fetchAndUnmarshalRequests(requests)
 
-	_, err = json.Marshal(results)
-	if err != nil {
-		a.Log.Info().Msgf("Error marshalling to json [%s]", err.Error())
+	b, st, mess := a.checkFetchResult(results[0], "item")
+	if !b {
+		c.JSON(st, gin.H{"message": mess})
+		return false
+	}
+	b, st, mess = a.checkFetchResult(results[1], "auction")
+	if !b {
+		c.JSON(st, gin.H{"message": mess})
+		return false
 	}
 
 	// now we have the item and auction deets we can check them
-	// TODO: business logic goes ere - need to check winner of auction matches user
+	b, st, mess = checkAuctionWinner(rv, &item, &auction)
+	if !b {
+		a.Log.Info().Msgf("Auction check failed for reviewer [%s]: [%s]", rv.ReviewedBy.String(), mess)
+		c.JSON(st, gin.H{"message": mess})
+		return false
+	}
+	return true
+}
 
-	var reviewId uuid.UUID
-	reviewId, _ = uuid.NewRandom()
+// ----------------------------------------------------------------------------
+
+// saveNewReview stores a review that has passed all its checks. the review
+// and the seller's score totals are updated together
+func (a *App) saveNewReview(c *gin.Context, rv *Review) {
+
+	reviewId, _ := uuid.NewRandom()
 	rv.ReviewId = reviewId
 
-	res := a.DB.Create(&rv)
-	if res.Error != nil {
+	err := a.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(rv).Error; err != nil {
+			return err
+		}
+		return addToSellerScores(tx, rv, 1)
+	})
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		// another request got there first so we return the review it created
+		a.Log.Info().Msgf("Duplicate review for reviewer [%s]", rv.ReviewedBy.String())
+		existingId, err := a.existingReviewId(rv)
+		if err != nil || existingId == uuid.Nil {
+			c.JSON(http.StatusConflict, gin.H{"message": "Review already exists"})
+			return
+		}
+		c.JSON(http.StatusConflict, gin.H{"message": "Review already exists", "review_id": existingId})
+		return
+	}
+	if err != nil {
 		a.Log.Info().Msgf("Review creation failed: [%s]", err.Error())
 		c.JSON(http.StatusInternalServerError, gin.H{"message": "Something went bang."})
 		return
@@ -86,72 +152,86 @@ func (a *App) createReview(c *gin.Context) {
 
 func (a *App) fetchReviewsByUUID(c *gin.Context, rk, uuidst string) {
 
-	b, st, mess := checkRequest(c)
-	if !b {
-		c.JSON(st, gin.H{"message": mess})
-		return
-	}
-
 	orderby := c.DefaultQuery("orderby", "created")
 	sort := c.DefaultQuery("sort", "desc")
 
-	if orderby != "created" {
-		a.Log.Info().Msgf("Not a valid orderby value: [%s]", orderby)
-		c.JSON(http.StatusBadRequest, gin.H{"message": "Not a valid orderby value"})
+	oss, err := orderClause(orderby, sort)
+	if err != nil {
+		a.Log.Info().Msgf("Bad order [%s] [%s]: [%s]", orderby, sort, err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
 		return
 	}
 
-	//TODO: add more possible values to orderby results
-	var oss string
-	if sort == "asc" || sort == "desc" {
-		oss = orderby + " " + sort
-	} else {
-		a.Log.Info().Msgf("Not a valid sort value: [%s]", sort)
-		c.JSON(http.StatusBadRequest, gin.H{"message": "Not a valid sort value"})
+	filters, err := parseReviewFilters(c)
+	if err != nil {
+		a.Log.Info().Msgf("Bad filter: [%s]", err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
 		return
 	}
 
-	id, err := uuid.Parse(uuidst)
+	lo, err := parseListOptions(c)
 	if err != nil {
-		a.Log.Info().Msgf("Not a uuid string: [%s]", err.Error())
-		c.JSON(http.StatusBadRequest, gin.H{"message": "Bad request"})
+		a.Log.Info().Msgf("Bad list options: [%s]", err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
 		return
 	}
 
-	var page int
-	page, err = strconv.Atoi(c.DefaultQuery("page", "1"))
+	id, err := uuid.Parse(uuidst)
 	if err != nil {
-		a.Log.Info().Msgf("Error in page value [%s]", err.Error())
-		c.JSON(http.StatusBadRequest, gin.H{"message": "Not a valid page value"})
+		a.Log.Info().Msgf("Not a uuid string: [%s]", err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Bad request"})
 		return
 	}
-	if page <= 0 {
-		page = 1
+
+	// only admins get to see deleted and hidden reviews
+	db := a.DB.Scopes(notHidden).Session(&gorm.Session{})
+	if c.Query("include_deleted") == "true" {
+		u, ok := currentUserIfAny(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"message": "Ooh you are naughty"})
+			return
+		}
+		if !u.isAdmin() {
+			a.Log.Info().Msgf("User [%s] is not an admin", u.PublicId)
+			c.JSON(http.StatusForbidden, gin.H{"message": "Only admins can see deleted reviews"})
+			return
+		}
+		db = a.DB.Unscoped().Session(&gorm.Session{})
 	}
+	db = db.Scopes(filters.Filter).Session(&gorm.Session{})
 
-	var ospsize int
-	ospsize, err = strconv.Atoi(os.Getenv("PAGESIZE"))
-	if err != nil {
-		a.Log.Info().Msgf("Error in pagesize env var [%s]", err.Error())
-		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error in pagesize env var"})
+	cursorMode := wantsCursor(c.Request.URL.Query())
+	if cursorMode && c.Query("page") != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Use either page or cursor, not both"})
 		return
 	}
-	var pagesize int
-	pagesize, err = strconv.Atoi(c.DefaultQuery("pagesize", os.Getenv("PAGESIZE")))
-	if err != nil {
-		a.Log.Info().Msgf("Error in pagesize querystring value [%s]", err.Error())
-		c.JSON(http.StatusBadRequest, gin.H{"message": "Error in pagesize querystring"})
+
+	var page, pagesize int
+	b, st, mess := a.checkPageParams(c, &page, &pagesize)
+	if !b {
+		c.JSON(st, gin.H{"message": mess})
 		return
 	}
-	if pagesize > 100 || pagesize <= 0 {
-		pagesize = ospsize
+
+	// embedded seller scores change with reviews outside the list
+	if !lo.EmbedSellerScores {
+		a.setLastModified(c, rk+" = ?", id)
+	}
+
+	if cursorMode {
+		if orderby != "created" {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "Cursors can only be used with orderby=created"})
+			return
+		}
+		a.fetchReviewsByCursor(c, db, rk, id, sort, pagesize, lo)
+		return
 	}
 
 	// get total records that match criteria
 	var tc int64
-	a.DB.Model(&Review{}).Where(rk + " = ?", id).Count(&tc)
+	db.Model(&Review{}).Where(rk + " = ?", id).Count(&tc)
 
-	rows, err := a.DB.Scopes(Paginate(page, pagesize)).Model(&Review{}).Where(rk + " = ?", id).Order(oss).Rows()
+	rows, err := db.Scopes(Paginate(page, pagesize), lo.Select).Model(&Review{}).Where(rk + " = ?", id).Order(oss).Rows()
 	if err != nil {
 		a.Log.Info().Msgf("Error fetching data: [%s]", err.Error())
 		c.JSON(http.StatusBadRequest, gin.H{"message": "Bad request"})
@@ -176,6 +256,8 @@ func (a *App) fetchReviewsByUUID(c *gin.Context, rk, uuidst string) {
 			c.JSON(http.StatusInternalServerError, gin.H{"message": "Something went bang"})
 			return
 		}
+		// hooks aren't run by ScanRows
+		rv.setDeleted()
 		reviews = append(reviews, rv)
 	}
 	if tc == 0 {
@@ -183,24 +265,24 @@ func (a *App) fetchReviewsByUUID(c *gin.Context, rk, uuidst string) {
 		return
 	}
 
-	// add prev/next url to output
-	var urls []interface{}
-	var totalPages int
-	if err = CreateURLS(c, &urls, &page, &pagesize, &totalPages, &tc); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-
+	totalPages := totalPagesFor(tc, pagesize)
 	if page > totalPages {
 		c.JSON(http.StatusBadRequest, gin.H{"message": "Page value is incorrect"})
 		return
 	}
 
+	// add prev/next url and links to output
+	urls, links := CreateURLS(c, page, totalPages)
+	resp := gin.H{"total_reviews": tc, "total_pages": totalPages, "current_page": page, "links": links}
 	if len(urls) > 0 {
-		c.JSON(http.StatusOK, gin.H{"total_reviews": tc, "total_pages": totalPages, "current_page": page, "urls": urls, "reviews": reviews})
+		resp["urls"] = urls
+	}
+	if err = a.addReviews(resp, reviews, lo); err != nil {
+		a.Log.Info().Msgf("Error adding reviews to response: [%s]", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Something went bang"})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"total_reviews": tc, "total_pages": totalPages, "current_page": page, "reviews": reviews})
+	c.JSON(http.StatusOK, resp)
 }
 
 // ----------------------------------------------------------------------------
@@ -212,25 +294,78 @@ func (a *App) getReview(c *gin.Context) {
 // ----------------------------------------------------------------------------
 
 func (a *App) getAllMyReviews(c *gin.Context) {
-
-	b, st, mess := a.bouncerSaysOk(c)
-	if !b {
-		c.JSON(st, gin.H{"message": mess})
-		return
-	}
-	a.fetchReviewsByUUID(c, "reviewed_by", mess)
+	a.fetchReviewsByUUID(c, "reviewed_by", currentPublicId(c))
 }
 
 // ----------------------------------------------------------------------------
 
 func (a *App) deleteReview(c *gin.Context) {
 
-	b, st, mess := a.bouncerSaysOk(c)
-	if !b {
-		c.JSON(st, gin.H{"message": mess})
+	pId := currentPublicId(c)
+
+	rId, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		a.Log.Info().Msgf("Not a uuid string: [%s]", err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Not a uuid string"})
+		return
+	}
+
+	// a reason for deleting is optional
+	var di ReasonInput
+	if err = bindOptionalReason(c, &di); err != nil {
+		a.Log.Info().Msgf("Input data does not match delete review: [%s]", err.Error())
+		badInput(c, err)
 		return
 	}
-	pId := mess
+	if di.Reason == "" {
+		di.Reason = "Deleted by reviewer"
+	}
+	actor, _ := uuid.Parse(pId)
+
+	// lock the review so the seller's score totals are only reduced once
+	err = a.DB.Transaction(func(tx *gorm.DB) error {
+		var rv Review
+		res := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("review_id = ? AND reviewed_by = ?", rId, pId).
+			Limit(1).
+			Find(&rv)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errNoReview
+		}
+		counted := countsTowardsScores(&rv)
+		now := time.Now()
+		err := tx.Model(&rv).Updates(map[string]interface{}{
+			"deleted_at":     now,
+			"deleted_reason": strings.TrimSpace(di.Reason),
+			"deleted_by":     actor,
+		}).Error
+		if err != nil {
+			return err
+		}
+		rv.DeletedAt = gorm.DeletedAt{Time: now, Valid: true}
+		return updateSellerScores(tx, &rv, counted)
+	})
+	if errors.Is(err, errNoReview) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Unable to delete review"})
+		return
+	}
+	if err != nil {
+		a.Log.Info().Msgf("Error deleting review [%s]", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Something went pop"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"review_deleted": rId})
+
+}
+
+// ----------------------------------------------------------------------------
+
+func (a *App) editReview(c *gin.Context) {
+
+	pId := currentPublicId(c)
 
 	rId, err := uuid.Parse(c.Param("id"))
 	if err != nil {
@@ -239,18 +374,91 @@ func (a *App) deleteReview(c *gin.Context) {
 		return
 	}
 
-	res := a.DB.Where("reviewed_by = ?", pId).Delete(&Review{}, rId)
-	if res.Error != nil {
-		a.Log.Info().Msgf("Error deleting review [%s]", res.Error.Error())
-		c.JSON(http.StatusInternalServerError, gin.H{"message": "Something went pop"})
+	var pi ReviewPatchInput
+	if err = c.ShouldBindJSON(&pi); err != nil {
+		a.Log.Info().Msgf("Input data does not match review patch: [%s]", err.Error())
+		badInput(c, err)
 		return
 	}
-	if res.RowsAffected == 1 {
-		c.JSON(http.StatusOK, gin.H{"review_deleted": rId})
-	} else {
-		c.JSON(http.StatusBadRequest, gin.H{"message": "Unable to delete review"})
+	if pi.empty() {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Nothing to update"})
+		return
 	}
 
+	// the old version is saved and the seller's score totals are swapped
+	// from the old scores to the new ones in the same transaction
+	err = a.DB.Transaction(func(tx *gorm.DB) error {
+		var rv Review
+		res := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("review_id = ? AND reviewed_by = ? AND hidden = ?", rId, pId, false).
+			Limit(1).
+			Find(&rv)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errNoReview
+		}
+		if time.Since(rv.Created) > editWindow() {
+			return errEditWindowClosed
+		}
+
+		var versions int64
+		if err := tx.Model(&ReviewRevision{}).Where("review_id = ?", rId).Count(&versions).Error; err != nil {
+			return err
+		}
+		written := rv.Created
+		if rv.EditedAt != nil {
+			written = *rv.EditedAt
+		}
+		revId, _ := uuid.NewRandom()
+		err := tx.Create(&ReviewRevision{
+			RevisionId: revId,
+			ReviewId:   rv.ReviewId,
+			Version:    int(versions) + 1,
+			Review:     rv.Review,
+			Overall:    rv.Overall,
+			PapCost:    rv.PapCost,
+			Comm:       rv.Comm,
+			AsDesc:     rv.AsDesc,
+			Created:    written,
+		}).Error
+		if err != nil {
+			return err
+		}
+		if err = addToSellerScores(tx, &rv, -1); err != nil {
+			return err
+		}
+
+		pi.applyTo(&rv)
+		err = tx.Model(&rv).Updates(map[string]interface{}{
+			"review":    rv.Review,
+			"overall":   rv.Overall,
+			"pap_cost":  rv.PapCost,
+			"comm":      rv.Comm,
+			"as_desc":   rv.AsDesc,
+			"edited":    true,
+			"edited_at": time.Now(),
+		}).Error
+		if err != nil {
+			return err
+		}
+		return addToSellerScores(tx, &rv, 1)
+	})
+	if errors.Is(err, errNoReview) {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Review not found"})
+		return
+	}
+	if errors.Is(err, errEditWindowClosed) {
+		c.JSON(http.StatusForbidden, gin.H{"message": "Review can no longer be edited"})
+		return
+	}
+	if err != nil {
+		a.Log.Info().Msgf("Error editing review [%s]", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Something went pop"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"review_edited": rId})
 }
 
 // ----------------------------------------------------------------------------
@@ -281,12 +489,6 @@ func (a *App) getAllReviewsByUser(c *gin.Context) {
 
 func (a *App) getMetadataOfUser(c *gin.Context) {
 
-	b, st, mess := checkRequest(c)
-	if !b {
-		c.JSON(st, gin.H{"message": mess})
-		return
-	}
-
 	// check user exists
 	var id uuid.UUID
 	err, sc := a.checkUserExists(c, &id)
@@ -300,16 +502,12 @@ func (a *App) getMetadataOfUser(c *gin.Context) {
 		return
 	}
 
-	// get total records that match criteria
-	var totalReviewsOf int64
-	a.DB.Model(&Review{}).Where("seller = ?", id).Count(&totalReviewsOf)
-	var totalReviewsBy int64
-	a.DB.Model(&Review{}).Where("reviewed_by = ?", id).Count(&totalReviewsBy)
-	scores, err := a.GetSellerScores(id)
+	mds, err := a.usersMetadata([]uuid.UUID{id})
 	if err != nil {
+		a.Log.Info().Msgf("Error getting user metadata [%s]", err.Error())
 		c.JSON(http.StatusInternalServerError, gin.H{"message": "Something went splat"})
 		return
 	}
-
-	c.JSON(http.StatusOK, gin.H{"public_id": id.String(), "scores": scores, "total_reviews_of_user": totalReviewsOf, "total_reviews_by_user": totalReviewsBy})
+	c.JSON(http.StatusOK, mds[0])
 }
+
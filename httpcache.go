@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// ----------------------------------------------------------------------------
+// h t t p   c a c h i n g
+// ----------------------------------------------------------------------------
+
+// defaultCacheControl is the Cache-Control header for each kind of public
+// route if its CACHE_CONTROL_<KIND> env var isn't set
+var defaultCacheControl = map[string]string{
+	"review":   "public, max-age=60",
+	"list":     "public, max-age=30",
+	"metadata": "public, max-age=300",
+	"search":   "public, max-age=30",
+}
+
+// responses to requests with a token can differ by user so must not be
+// kept by shared caches
+const privateCacheControl = "private, no-cache"
+
+// lastModifiedKey is where handlers put when the data in their response
+// last changed
+const lastModifiedKey = "last_modified"
+
+// httpCache holds the Cache-Control header for each kind of route
+type httpCache struct {
+	control map[string]string
+}
+
+// ----------------------------------------------------------------------------
+
+// InitialiseHTTPCache reads the Cache-Control header for each kind of route.
+// an empty CACHE_CONTROL_<KIND> leaves the header out
+func (a *App) InitialiseHTTPCache() {
+
+	a.Log.Info().Msg("Initialising http cache")
+	control := make(map[string]string, len(defaultCacheControl))
+	for kind, def := range defaultCacheControl {
+		control[kind] = def
+		if cc, ok := os.LookupEnv("CACHE_CONTROL_" + strings.ToUpper(kind)); ok {
+			control[kind] = cc
+		}
+	}
+	a.HTTPCache = &httpCache{control: control}
+}
+
+// ----------------------------------------------------------------------------
+
+// setLastModified records when any review matching the query was last
+// created, edited, deleted or updated so it can be sent as Last-Modified.
+// deleted and hidden reviews are included so a review dropping out of a list
+// moves it on too. routes that don't call it only get an ETag
+func (a *App) setLastModified(c *gin.Context, query string, args ...interface{}) {
+
+	var latest sql.NullTime
+	err := a.DB.Unscoped().Model(&Review{}).
+		Select("MAX(GREATEST(created, edited_at, deleted_at, updated))").
+		Where(query, args...).
+		Scan(&latest).Error
+	if err != nil {
+		a.Log.Info().Msgf("Error getting last modified [%s]", err.Error())
+		return
+	}
+	if latest.Valid {
+		c.Set(lastModifiedKey, latest.Time)
+	}
+}
+
+// ----------------------------------------------------------------------------
+
+// bufferedWriter holds on to the body so the etag can be worked out before
+// anything is sent
+type bufferedWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (bw *bufferedWriter) Write(b []byte) (int, error) {
+	return bw.body.Write(b)
+}
+
+func (bw *bufferedWriter) WriteString(s string) (int, error) {
+	return bw.body.WriteString(s)
+}
+
+// ----------------------------------------------------------------------------
+
+// httpCaching adds an ETag, Cache-Control and Last-Modified, if the handler
+// set it, to successful responses and answers If-None-Match and
+// If-Modified-Since with a 304 if the client already has the content. kind
+// picks the Cache-Control
+func (a *App) httpCaching(kind string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		// errors differ by token too so every response varies on it
+		c.Header("Vary", "X-Access-Token")
+
+		bw := &bufferedWriter{ResponseWriter: c.Writer}
+		c.Writer = bw
+		c.Next()
+		c.Writer = bw.ResponseWriter
+
+		if bw.Status() != http.StatusOK {
+			_, _ = c.Writer.Write(bw.body.Bytes())
+			return
+		}
+
+		sum := sha256.Sum256(bw.body.Bytes())
+		etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+		c.Header("ETag", etag)
+
+		// Last-Modified only has seconds so anything finer is dropped or
+		// If-Modified-Since would never match
+		var lastMod time.Time
+		if v, ok := c.Get(lastModifiedKey); ok {
+			lastMod = v.(time.Time).UTC().Truncate(time.Second)
+			c.Header("Last-Modified", lastMod.Format(http.TimeFormat))
+		}
+		if hc := a.HTTPCache; hc != nil {
+			cc := hc.control[kind]
+			if c.GetHeader("X-Access-Token") != "" {
+				cc = privateCacheControl
+			}
+			if cc != "" {
+				c.Header("Cache-Control", cc)
+			}
+		}
+
+		if notModified(c.Request, etag, lastMod) {
+			c.Writer.WriteHeader(http.StatusNotModified)
+			c.Writer.WriteHeaderNow()
+			return
+		}
+		_, _ = c.Writer.Write(bw.body.Bytes())
+	}
+}
+
+// ----------------------------------------------------------------------------
+
+// notModified follows rfc 9110. If-Modified-Since is only looked at if
+// there's no If-None-Match
+func notModified(r *http.Request, etag string, lastMod time.Time) bool {
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, t := range strings.Split(inm, ",") {
+			t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+			if t == "*" || t == etag {
+				return true
+			}
+		}
+		return false
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && !lastMod.IsZero() {
+		t, err := http.ParseTime(ims)
+		return err == nil && !lastMod.After(t)
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"net/http"
+)
+
+// ----------------------------------------------------------------------------
+// i n t e r n a l   r o u t e s
+// ----------------------------------------------------------------------------
+
+// these are called by other poptape microservices with an api key rather
+// than by users
+
+// ----------------------------------------------------------------------------
+
+func (a *App) getInternalScores(c *gin.Context) {
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		a.Log.Info().Msgf("Not a uuid string: [%s]", err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Not a uuid string"})
+		return
+	}
+
+	// the calling service already knows the user exists so we don't ask authy
+	mds, err := a.usersMetadata([]uuid.UUID{id})
+	if err != nil {
+		a.Log.Info().Msgf("Error getting user metadata [%s]", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Something went splat"})
+		return
+	}
+	c.JSON(http.StatusOK, mds[0])
+}
+
+// ----------------------------------------------------------------------------
+
+// createSystemReview lets a service post a review on behalf of a user.
+// system reviews get the same item, auction and winner checks as user reviews
+func (a *App) createSystemReview(c *gin.Context) {
+
+	svc := currentService(c)
+
+	var ri ReviewInput
+	if err := c.ShouldBindJSON(&ri); err != nil {
+		a.Log.Info().Msgf("Input data does not match review: [%s]", err.Error())
+		badInput(c, err)
+		return
+	}
+	rv := ri.toReview()
+	rv.PostedBy = svc.Name
+
+	existingId, err := a.existingReviewId(&rv)
+	if err != nil {
+		a.Log.Info().Msgf("Error checking for existing review [%s]", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Something went bang."})
+		return
+	}
+	if existingId != uuid.Nil {
+		c.JSON(http.StatusConflict, gin.H{"message": "Review already exists", "review_id": existingId})
+		return
+	}
+
+	// services get the same auction checks as users. any access token they
+	// send for the reviewer is passed on to the item and auction services
+	if !a.checkReviewedAuction(c, &rv, c.GetHeader("X-Access-Token")) {
+		return
+	}
+
+	a.Log.Info().Msgf("Service [%s] posting review for reviewer [%s]", svc.Name, rv.ReviewedBy.String())
+	a.saveNewReview(c, &rv)
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"crypto/rsa"
+	"errors"
+	"github.com/MicahParks/keyfunc/v3"
+	"github.com/golang-jwt/jwt/v5"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ----------------------------------------------------------------------------
+// l o c a l   j w t   v e r i f i c a t i o n
+// ----------------------------------------------------------------------------
+
+// jwtLeeway allows for a little clock drift between us and authy
+const jwtLeeway = 30 * time.Second
+
+// defaultJWKSRefreshMins is how often the public keys are reloaded if
+// JWKS_REFRESH_MINS isn't set
+const defaultJWKSRefreshMins = 60
+
+// minRSAKeyBits is the smallest rsa key we'll accept a signature from
+const minRSAKeyBits = 2048
+
+var (
+	errTokenAlg     = errors.New("token algorithm not allowed")
+	errTokenNoUser  = errors.New("token has no public_id")
+	errTokenKeySize = errors.New("token key is too small")
+)
+
+type jwtClaims struct {
+	PublicId string   `json:"public_id"`
+	Roles    []string `json:"roles"`
+	Scope    string   `json:"scope"`
+	jwt.RegisteredClaims
+}
+
+// jwtConfig is where the keys come from and what the tokens must have been
+// issued for. OnRefreshError is told when the jwks url can't be reloaded
+type jwtConfig struct {
+	Secret         string
+	JWKSFile       string
+	JWKSURL        string
+	Issuer         string
+	Audience       string
+	Refresh        time.Duration
+	OnRefreshError func(err error)
+}
+
+// jwtVerifier checks access tokens without having to call authy. HS256
+// tokens are checked with a shared secret and RS256 tokens with public
+// keys loaded from a jwks file or url
+type jwtVerifier struct {
+	secret []byte
+	keys   keyfunc.Keyfunc
+	parser *jwt.Parser
+}
+
+// ----------------------------------------------------------------------------
+
+// InitialiseJWT sets up local token verification if JWT_SECRET, JWKS_FILE
+// or JWKS_URL are set. if none of them are every token is checked by authy.
+// keys from JWKS_URL are refreshed until ctx is done
+func (a *App) InitialiseJWT(ctx context.Context) {
+
+	cfg := jwtConfig{
+		Secret:   os.Getenv("JWT_SECRET"),
+		JWKSFile: os.Getenv("JWKS_FILE"),
+		JWKSURL:  os.Getenv("JWKS_URL"),
+		Issuer:   os.Getenv("JWT_ISSUER"),
+		Audience: os.Getenv("JWT_AUDIENCE"),
+		Refresh:  jwksRefreshInterval(),
+		OnRefreshError: func(err error) {
+			a.Log.Error().Msgf("Unable to refresh jwks [%s]", err.Error())
+		},
+	}
+	if cfg.Secret == "" && cfg.JWKSFile == "" && cfg.JWKSURL == "" {
+		a.Log.Info().Msg("No jwt config found, tokens will be checked by authy")
+		a.JWT = nil
+		return
+	}
+	if cfg.Issuer == "" || cfg.Audience == "" {
+		a.Log.Fatal().Msg("JWT_ISSUER and JWT_AUDIENCE must be set to check tokens locally")
+	}
+
+	a.Log.Info().Msg("Initialising jwt verification")
+	v, err := newJWTVerifier(ctx, cfg)
+	if err != nil {
+		a.Log.Fatal().Msgf("Unable to set up jwt verification [%s]", err.Error())
+	}
+	a.JWT = v
+}
+
+// ----------------------------------------------------------------------------
+
+// newJWTVerifier loads the keys in cfg. a jwks url is fetched in the
+// background and refreshed every cfg.Refresh until ctx is done, a jwks
+// file is only read the once
+func newJWTVerifier(ctx context.Context, cfg jwtConfig) (*jwtVerifier, error) {
+
+	v := &jwtVerifier{secret: []byte(cfg.Secret)}
+	var err error
+	switch {
+	case cfg.JWKSFile != "":
+		var data []byte
+		if data, err = os.ReadFile(cfg.JWKSFile); err == nil {
+			v.keys, err = keyfunc.NewJWKSetJSON(data)
+		}
+	case cfg.JWKSURL != "":
+		override := keyfunc.Override{
+			RefreshInterval: cfg.Refresh,
+			HTTPTimeout:     10 * time.Second,
+		}
+		if cfg.OnRefreshError != nil {
+			override.RefreshErrorHandlerFunc = func(string) func(context.Context, error) {
+				return func(_ context.Context, err error) { cfg.OnRefreshError(err) }
+			}
+		}
+		v.keys, err = keyfunc.NewDefaultOverrideCtx(ctx, []string{cfg.JWKSURL}, override)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var methods []string
+	if len(v.secret) > 0 {
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	if v.keys != nil {
+		methods = append(methods, jwt.SigningMethodRS256.Alg())
+	}
+	v.parser = jwt.NewParser(
+		jwt.WithValidMethods(methods),
+		jwt.WithIssuer(cfg.Issuer),
+		jwt.WithAudience(cfg.Audience),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(jwtLeeway),
+	)
+	return v, nil
+}
+
+// ----------------------------------------------------------------------------
+
+// jwksRefreshInterval returns how often the public keys are reloaded.
+// defaults to 60 minutes if not set or not valid
+func jwksRefreshInterval() time.Duration {
+	mins, err := strconv.Atoi(os.Getenv("JWKS_REFRESH_MINS"))
+	if err != nil || mins <= 0 {
+		mins = defaultJWKSRefreshMins
+	}
+	return time.Duration(mins) * time.Minute
+}
+
+// ----------------------------------------------------------------------------
+
+// authyFallback is true if tokens that fail local verification should be
+// passed on to authy
+func authyFallback() bool {
+	return os.Getenv("AUTHY_FALLBACK") == "true"
+}
+
+// ----------------------------------------------------------------------------
+
+// key picks the key to check a token with. HS256 tokens only get the secret
+// and RS256 tokens only the jwks so one can't be passed off as the other
+func (v *jwtVerifier) key(t *jwt.Token) (interface{}, error) {
+
+	switch t.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		return v.secret, nil
+	case *jwt.SigningMethodRSA:
+		key, err := v.keys.Keyfunc(t)
+		if err != nil {
+			return nil, err
+		}
+		return bigEnoughKeys(key)
+	}
+	return nil, errTokenAlg
+}
+
+// ----------------------------------------------------------------------------
+
+// bigEnoughKeys drops any rsa keys smaller than minRSAKeyBits. tokens
+// without a key id get every key in the set to try
+func bigEnoughKeys(key interface{}) (interface{}, error) {
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if k.N.BitLen() < minRSAKeyBits {
+			return nil, errTokenKeySize
+		}
+		return k, nil
+	case jwt.VerificationKeySet:
+		var keys []jwt.VerificationKey
+		for _, vk := range k.Keys {
+			if pub, ok := vk.(*rsa.PublicKey); ok && pub.N.BitLen() >= minRSAKeyBits {
+				keys = append(keys, pub)
+			}
+		}
+		if len(keys) == 0 {
+			return nil, errTokenKeySize
+		}
+		return jwt.VerificationKeySet{Keys: keys}, nil
+	}
+	return nil, errTokenAlg
+}
+
+// ----------------------------------------------------------------------------
+
+// verify checks the token's signature, times, issuer and audience and
+// returns the user
+func (v *jwtVerifier) verify(token string) (user, error) {
+
+	var claims jwtClaims
+	if _, err := v.parser.ParseWithClaims(token, &claims, v.key); err != nil {
+		return user{}, err
+	}
+	if claims.PublicId == "" {
+		return user{}, errTokenNoUser
+	}
+
+	// scopes in a jwt are a space separated string
+	return user{PublicId: claims.PublicId, Roles: claims.Roles, Scopes: strings.Fields(claims.Scope)}, nil
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"net/http"
+	"sync"
+)
+
+// ----------------------------------------------------------------------------
+// b a t c h   u s e r   m e t a d a t a
+// ----------------------------------------------------------------------------
+
+// defaultUserCheckConcurrency is how many users are checked with authy at
+// once if USER_CHECK_CONCURRENCY isn't set
+const defaultUserCheckConcurrency = 8
+
+// userMetadataResult is what's returned for each public_id in a batch
+type userMetadataResult struct {
+	Status   int           `json:"status"`
+	Message  string        `json:"message,omitempty"`
+	Metadata *MetadataResp `json:"metadata,omitempty"`
+}
+
+// metadataRow is the part of a seller's metadata that depends on when it's
+// asked, worked out from their reviews. the weighted sums are the scores
+// times each review's weight
+type metadataRow struct {
+	Seller      uuid.UUID
+	Total       int64
+	Weight      float64
+	OverallW    float64
+	PapCostW    float64
+	CommW       float64
+	AsDescW     float64
+	Last30Days  int64
+	Last90Days  int64
+	Last365Days int64
+}
+
+// ----------------------------------------------------------------------------
+
+// getUsersMetadata is getMetadataOfUser for a list of users. a user that
+// doesn't exist or can't be checked gets a status and message instead of
+// failing the whole batch
+func (a *App) getUsersMetadata(c *gin.Context) {
+
+	var ui UsersMetadataInput
+	if err := c.ShouldBindJSON(&ui); err != nil {
+		a.Log.Info().Msgf("Input data does not match users: [%s]", err.Error())
+		badInput(c, err)
+		return
+	}
+	maxIds := envInt("BATCH_MAX_IDS", defaultBatchMaxIds)
+	if len(ui.PublicIds) == 0 || len(ui.PublicIds) > maxIds {
+		c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("A batch must have between 1 and %d ids", maxIds)})
+		return
+	}
+
+	results := make(map[string]userMetadataResult, len(ui.PublicIds))
+	sent := make(map[uuid.UUID]string, len(ui.PublicIds))
+	var ids []uuid.UUID
+	for _, s := range ui.PublicIds {
+		id, err := uuid.Parse(s)
+		if err != nil {
+			results[s] = userMetadataResult{Status: http.StatusBadRequest, Message: "Not a uuid string"}
+			continue
+		}
+		if _, ok := sent[id]; !ok {
+			sent[id] = s
+			ids = append(ids, id)
+		}
+	}
+
+	// every user is checked with authy so each one counts as a request, the
+	// first has already been counted by the middleware
+	if !a.chargeRateLimit(c, "public", len(ids)-1) {
+		return
+	}
+
+	var existing []uuid.UUID
+	for i, sc := range a.checkUsersExist(ids) {
+		switch sc {
+		case http.StatusOK:
+			existing = append(existing, ids[i])
+		case http.StatusNotFound:
+			results[sent[ids[i]]] = userMetadataResult{Status: http.StatusNotFound, Message: "User doesn't exist"}
+		default:
+			results[sent[ids[i]]] = userMetadataResult{Status: http.StatusBadGateway, Message: "Unable to check user"}
+		}
+	}
+
+	mds, err := a.usersMetadata(existing)
+	if err != nil {
+		a.Log.Info().Msgf("Error getting users metadata [%s]", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Something went splat"})
+		return
+	}
+	for i := range mds {
+		results[sent[existing[i]]] = userMetadataResult{Status: http.StatusOK, Metadata: &mds[i]}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"results": results})
+}
+
+// ----------------------------------------------------------------------------
+
+// checkUsersExist checks the users with authy, a few at a time, and returns
+// the status code for each in the same order
+func (a *App) checkUsersExist(ids []uuid.UUID) []int {
+
+	codes := make([]int, len(ids))
+	n := envInt("USER_CHECK_CONCURRENCY", defaultUserCheckConcurrency)
+	if n < 1 {
+		n = 1
+	}
+	sem := make(chan struct{}, n)
+
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		go func(idx int, id uuid.UUID) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			err, sc := a.userExists(id)
+			if err != nil {
+				a.Log.Info().Msgf("Checking user [%s] failed [%s]", id.String(), err.Error())
+			}
+			codes[idx] = sc
+		}(i, id)
+	}
+	wg.Wait()
+	return codes
+}
+
+// ----------------------------------------------------------------------------
+
+// usersMetadata builds the metadata for each user. the scores, written
+// counts and distributions come from the stored totals, only the weighted
+// scores and recent counts need a grouped query of the reviews
+func (a *App) usersMetadata(ids []uuid.UUID) ([]MetadataResp, error) {
+
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	var sss []SellerScore
+	if err := a.DB.Where("seller IN ?", ids).Find(&sss).Error; err != nil {
+		return nil, err
+	}
+	bySeller := make(map[uuid.UUID]SellerScore, len(sss))
+	for _, ss := range sss {
+		bySeller[ss.Seller] = ss
+	}
+
+	var counts []ScoreCount
+	if err := a.DB.Where("seller IN ?", ids).Find(&counts).Error; err != nil {
+		return nil, err
+	}
+	dists := make(map[uuid.UUID]Distribution, len(ids))
+	for _, id := range ids {
+		dists[id] = newDistribution()
+	}
+	for _, sc := range counts {
+		dists[sc.Seller].add(sc)
+	}
+
+	var rows []metadataRow
+	err := a.DB.Table("(?) as weighted", a.DB.Model(&Review{}).
+		Scopes(notHidden).
+		Select("seller, overall, pap_cost, comm, as_desc, created, "+
+			"POWER(0.5, EXTRACT(EPOCH FROM (NOW() - created)) / 86400 / ?) as weight", scoreHalfLife()).
+		Where("seller IN ?", ids)).
+		Select("seller, COUNT(*) as total, SUM(weight) as weight, " +
+			"SUM(overall * weight) as overall_w, SUM(pap_cost * weight) as pap_cost_w, " +
+			"SUM(comm * weight) as comm_w, SUM(as_desc * weight) as as_desc_w, " +
+			"COUNT(*) FILTER (WHERE created >= NOW() - INTERVAL '30 days') as last30_days, " +
+			"COUNT(*) FILTER (WHERE created >= NOW() - INTERVAL '90 days') as last90_days, " +
+			"COUNT(*) FILTER (WHERE created >= NOW() - INTERVAL '365 days') as last365_days").
+		Group("seller").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	byRow := make(map[uuid.UUID]metadataRow, len(rows))
+	for _, r := range rows {
+		byRow[r.Seller] = r
+	}
+
+	platform, err := a.GetPlatformTotals()
+	if err != nil {
+		return nil, err
+	}
+
+	pw := scorePriorWeight()
+	mds := make([]MetadataResp, len(ids))
+	for i, id := range ids {
+		ss, r := bySeller[id], byRow[id]
+		avgs := ss.averages()
+
+		md := MetadataResp{PublicId: id.String()}
+		md.Scores = scoresFromAverages(avgs)
+		md.Scores.Bayesian = bayesianScores(avgs, platform.without(ss).averages(), pw)
+		md.WeightedScores = scoresFromAverages(averagesOf(
+			[4]float64{r.OverallW, r.PapCostW, r.CommW, r.AsDescW}, r.Weight, int(r.Total)))
+		md.Distribution = dists[id]
+		md.Distribution.Recent = RecentCounts{Last30Days: r.Last30Days, Last90Days: r.Last90Days, Last365Days: r.Last365Days}
+		md.TotalReviewsOfUser = int(ss.ReviewCount)
+		md.TotalReviewsByUser = int(ss.WrittenCount)
+		mds[i] = md
+	}
+	return mds, nil
+}
+
+// ----------------------------------------------------------------------------
+
+// averagesOf divides the overall, pap_cost, comm and as_desc sums by n
+func averagesOf(sums [4]float64, n float64, count int) ReviewAverages {
+
+	if n == 0 {
+		return ReviewAverages{ReviewCount: count}
+	}
+	return ReviewAverages{
+		ReviewCount:    count,
+		OverallAverage: float32(sums[0] / n),
+		PapCostAverage: float32(sums[1] / n),
+		CommAverage:    float32(sums[2] / n),
+		AsDescAverage:  float32(sums[3] / n),
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"net/http"
+	"regexp"
+)
+
+// ----------------------------------------------------------------------------
+// m i d d l e w a r e
+// ----------------------------------------------------------------------------
+
+// keys used to keep request details in the gin context
+const (
+	userKey      = "user"
+	publicIdKey  = "public_id"
+	requestIdKey = "request_id"
+	serviceKey   = "service"
+)
+
+// apiKeyHeader holds the key other microservices use for internal routes
+const apiKeyHeader = "X-Api-Key"
+
+// requestIdHeader is passed on to the other microservices we call so a
+// request can be followed through the logs
+const requestIdHeader = "X-Request-Id"
+
+// validRequestId stops callers putting anything odd into our logs
+var validRequestId = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// ----------------------------------------------------------------------------
+
+// requestId uses the caller's request id if it looks sane or makes a new one
+func (a *App) requestId() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		id := c.GetHeader(requestIdHeader)
+		if !validRequestId.MatchString(id) {
+			id = uuid.NewString()
+		}
+		c.Set(requestIdKey, id)
+		c.Header(requestIdHeader, id)
+		a.Log.Debug().Msgf("Request [%s] %s %s", id, c.Request.Method, c.Request.URL.Path)
+		c.Next()
+	}
+}
+
+// ----------------------------------------------------------------------------
+
+// jsonOnly turns away requests that won't take a json response or have a
+// body that isn't json
+func jsonOnly() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		b, st, mess := checkRequest(c)
+		if !b {
+			c.AbortWithStatusJSON(st, gin.H{"message": mess})
+			return
+		}
+		c.Next()
+	}
+}
+
+// ----------------------------------------------------------------------------
+
+// authRequired lets the request through if the bouncer says the access
+// token is ok and puts the user in the context
+func (a *App) authRequired() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		b, st, mess, u := a.bouncerSaysOk(c)
+		if !b {
+			c.AbortWithStatusJSON(st, gin.H{"message": mess})
+			return
+		}
+		c.Set(userKey, u)
+		c.Set(publicIdKey, u.PublicId)
+		c.Next()
+	}
+}
+
+// ----------------------------------------------------------------------------
+
+// optionalAuth is authRequired for public routes that show more to some
+// users. if there's a token the bouncer is happy with the user is put in
+// the context, otherwise the request carries on without one
+func (a *App) optionalAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		if c.GetHeader("X-Access-Token") != "" {
+			if b, _, _, u := a.bouncerSaysOk(c); b {
+				c.Set(userKey, u)
+				c.Set(publicIdKey, u.PublicId)
+			}
+		}
+		c.Next()
+	}
+}
+
+// ----------------------------------------------------------------------------
+
+// adminOnly must come after authRequired and only lets users with one of
+// the ADMIN_ROLES through
+func (a *App) adminOnly() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		u := currentUser(c)
+		if !u.isAdmin() {
+			a.Log.Info().Msgf("User [%s] is not an admin", u.PublicId)
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "Only admins can do that"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// ----------------------------------------------------------------------------
+
+// apiKeyRequired lets other microservices in if their api key has the scope
+// needed and puts the service in the context. it sits beside authRequired
+// rather than after it as these callers have no user
+func (a *App) apiKeyRequired(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		key := c.GetHeader(apiKeyHeader)
+		if key == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "Missing api key"})
+			return
+		}
+		svc, ok, err := a.lookupApiKey(key)
+		if err != nil {
+			a.Log.Info().Msgf("Error looking up api key [%s]", err.Error())
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Something went bang"})
+			return
+		}
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "Invalid api key"})
+			return
+		}
+		if !svc.allows(scope) {
+			a.Log.Info().Msgf("Service [%s] key [%s] doesn't have scope [%s]", svc.Name, svc.KeyId, scope)
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "Api key does not allow that"})
+			return
+		}
+		a.Log.Debug().Msgf("Request [%s] from service [%s]", currentRequestId(c), svc.Name)
+		c.Set(serviceKey, svc)
+		c.Next()
+	}
+}
+
+// ----------------------------------------------------------------------------
+
+func currentUser(c *gin.Context) user {
+	u, _ := c.MustGet(userKey).(user)
+	return u
+}
+
+// ----------------------------------------------------------------------------
+
+// currentUserIfAny is currentUser for routes where the user is optional
+func currentUserIfAny(c *gin.Context) (user, bool) {
+	u, ok := c.Get(userKey)
+	if !ok {
+		return user{}, false
+	}
+	uu, ok := u.(user)
+	return uu, ok
+}
+
+// ----------------------------------------------------------------------------
+
+func currentPublicId(c *gin.Context) string {
+	return c.GetString(publicIdKey)
+}
+
+// ----------------------------------------------------------------------------
+
+func currentRequestId(c *gin.Context) string {
+	return c.GetString(requestIdKey)
+}
+
+// ----------------------------------------------------------------------------
+
+func currentService(c *gin.Context) service {
+	svc, _ := c.MustGet(serviceKey).(service)
+	return svc
+}
@@ -6,12 +6,16 @@ import (
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/transform"
 	"gorm.io/gorm"
 	"io"
 	"math"
+	"mime"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -20,33 +24,35 @@ import (
 // h e l p e r   f u n c t i o n s
 // ----------------------------------------------------------------------------
 
-func CreateURLS(c *gin.Context, urls *[]interface{}, page, pagesize, totalPages *int, tc *int64) error {
-	var prev string
-	var next string
-	*totalPages = int(math.Ceil(float64(*tc)/float64(*pagesize)))
-	prev = `{ "prev_url": "`+os.Getenv("PREVNEXTURL")+c.Request.URL.Path+`?page=`+strconv.Itoa(*page-1)+`" }`
-	next = `{ "next_url": "`+os.Getenv("PREVNEXTURL")+c.Request.URL.Path+`?page=`+strconv.Itoa(*page+1)+`" }`
-
-	var prevobj map[string]interface{}
-	_ = json.Unmarshal([]byte(prev), &prevobj)
-
-	var nextobj map[string]interface{}
-	_ = json.Unmarshal([]byte(next), &nextobj)
-
-	if *page > 1 && *page < *totalPages {
-		// we have prev and next
-		*urls = append(*urls, prevobj)
-		*urls = append(*urls, nextobj)
-	} else if *page > 1 {
-		// only prev
-		*urls = append(*urls, prevobj)
-	} else if *page == *totalPages {
-		// no prev or next
-	} else if *page < *totalPages {
-		// only next
-		*urls = append(*urls, nextobj)
+// checkPageParams reads the page and pagesize query strings. pagesize falls
+// back to the PAGESIZE env var if it's missing or out of range
+func (a *App) checkPageParams(c *gin.Context, page, pagesize *int) (bool, int, string) {
+
+	var err error
+	*page, err = strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil {
+		a.Log.Info().Msgf("Error in page value [%s]", err.Error())
+		return false, http.StatusBadRequest, "Not a valid page value"
 	}
-	return nil
+	if *page <= 0 {
+		*page = 1
+	}
+
+	var ospsize int
+	ospsize, err = strconv.Atoi(os.Getenv("PAGESIZE"))
+	if err != nil {
+		a.Log.Info().Msgf("Error in pagesize env var [%s]", err.Error())
+		return false, http.StatusInternalServerError, "Error in pagesize env var"
+	}
+	*pagesize, err = strconv.Atoi(c.DefaultQuery("pagesize", os.Getenv("PAGESIZE")))
+	if err != nil {
+		a.Log.Info().Msgf("Error in pagesize querystring value [%s]", err.Error())
+		return false, http.StatusBadRequest, "Error in pagesize querystring"
+	}
+	if *pagesize > 100 || *pagesize <= 0 {
+		*pagesize = ospsize
+	}
+	return true, http.StatusOK, ""
 }
 
 // ----------------------------------------------------------------------------
@@ -61,19 +67,127 @@ func Paginate(page, pagesize int) func(db *gorm.DB) *gorm.DB {
 
 // ----------------------------------------------------------------------------
 
+// notHidden leaves out reviews that have been hidden by an admin
+func notHidden(db *gorm.DB) *gorm.DB {
+	return db.Where("hidden = ?", false)
+}
+
+// ----------------------------------------------------------------------------
+
+// bindOptionalReason binds a reason if a body has been sent. the body can
+// be left out altogether
+func bindOptionalReason(c *gin.Context, ri *ReasonInput) error {
+
+	if c.Request.Body == nil || c.Request.ContentLength == 0 {
+		return nil
+	}
+	if err := c.ShouldBindJSON(ri); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// ----------------------------------------------------------------------------
+
+// checkRequest makes sure the caller will take a json response and, if
+// there's a body, that it is json. bodies in other charsets are turned into
+// utf-8 as they're read
 func checkRequest(c *gin.Context) (bool, int, string) {
 
-	ct := c.GetHeader("Content-type")
+	if !acceptsJSON(c.GetHeader("Accept")) {
+		return false, http.StatusNotAcceptable, "Response can only be json"
+	}
+	if !hasBody(c.Request) {
+		return true, http.StatusOK, ""
+	}
 
-	if !(ct == "application/json" ||
-		ct == "application/json; charset=UTF-8") {
-		return false, http.StatusBadRequest, "Request must be json"
+	mt, params, err := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	if err != nil || !isJSONMediaType(mt) {
+		return false, http.StatusUnsupportedMediaType, "Request must be json"
+	}
+	cs := strings.ToLower(params["charset"])
+	if cs == "" || cs == "utf-8" || cs == "utf8" {
+		return true, http.StatusOK, ""
+	}
+	enc, err := htmlindex.Get(cs)
+	if err != nil {
+		return false, http.StatusUnsupportedMediaType, "Unsupported charset"
+	}
+	if name, _ := htmlindex.Name(enc); name != "utf-8" {
+		c.Request.Body = io.NopCloser(transform.NewReader(c.Request.Body, enc.NewDecoder()))
+		c.Request.ContentLength = -1
 	}
 	return true, http.StatusOK, ""
 }
 
 // ----------------------------------------------------------------------------
 
+// acceptsJSON is true if the most specific media range in an accept header
+// that matches json has a q above 0, so "application/json;q=0, */*" is a no.
+// no accept header means anything will do
+func acceptsJSON(accept string) bool {
+
+	if strings.TrimSpace(accept) == "" {
+		return true
+	}
+	best, ok := 0, false
+	for _, mr := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(mr))
+		if err != nil {
+			continue
+		}
+		var rank int
+		switch {
+		case mt == "application/json":
+			rank = 4
+		case isJSONMediaType(mt):
+			rank = 3
+		case mt == "application/*":
+			rank = 2
+		case mt == "*/*":
+			rank = 1
+		default:
+			continue
+		}
+		if rank < best {
+			continue
+		}
+		q := 1.0
+		if qs, found := params["q"]; found {
+			if q, err = strconv.ParseFloat(qs, 64); err != nil {
+				continue
+			}
+		}
+		// q=0 means not acceptable and wins a tie with the same range
+		if rank > best || q <= 0 {
+			best, ok = rank, q > 0
+		}
+	}
+	return ok
+}
+
+// ----------------------------------------------------------------------------
+
+// isJSONMediaType expects a media type already lower cased by
+// mime.ParseMediaType. it allows suffixes like application/merge-patch+json
+func isJSONMediaType(mt string) bool {
+	return mt == "application/json" ||
+		(strings.HasPrefix(mt, "application/") && strings.HasSuffix(mt, "+json"))
+}
+
+// ----------------------------------------------------------------------------
+
+// hasBody is true if the request has or might have a body. chunked
+// requests have a content length of -1
+func hasBody(r *http.Request) bool {
+	if r.Body == nil || r.Body == http.NoBody {
+		return false
+	}
+	return r.ContentLength != 0
+}
+
+// ----------------------------------------------------------------------------
+
 func (a *App) checkUserExists(c *gin.Context, id *uuid.UUID) (error, int) {
 
 	var err error
@@ -82,9 +196,15 @@ func (a *App) checkUserExists(c *gin.Context, id *uuid.UUID) (error, int) {
 		a.Log.Info().Msgf("Not a uuid string: [%s]", err.Error())
 		return err, 400
 	}
+	return a.userExists(*id)
+}
+
+// ----------------------------------------------------------------------------
 
-	var req *http.Request
-	req, err = http.NewRequest("GET", os.Getenv("AUTHYUSER")+id.String(), nil)
+// userExists asks authy if there is a user with this public_id
+func (a *App) userExists(id uuid.UUID) (error, int) {
+
+	req, err := http.NewRequest("GET", os.Getenv("AUTHYUSER")+id.String(), nil)
 	if err != nil {
 		a.Log.Info().Msgf("Error is [%s]", err.Error())
 		return err, 400
@@ -96,6 +216,7 @@ func (a *App) checkUserExists(c *gin.Context, id *uuid.UUID) (error, int) {
 		a.Log.Info().Msgf("HTTP req failed with [%s]", e.Error())
 		return e, 400
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode == 200 {
 		return nil, resp.StatusCode
 	}
@@ -170,25 +291,182 @@ func (a *App) fetchAndUnmarshalRequests(requests []HTTPRequest) []HTTPResponse {
 
 // ----------------------------------------------------------------------------
 
-func (a *App) GetSellerScores(sellerId uuid.UUID) (Scores, error) {
-	var avgs ReviewAverages
+func (a *App) checkFetchResult(res HTTPResponse, name string) (bool, int, string) {
+
+	if res.StatusCode == http.StatusNotFound {
+		a.Log.Info().Msgf("No %s found", name)
+		return false, http.StatusNotFound, "No " + name + " found"
+	}
+	if res.Err != nil {
+		a.Log.Info().Msgf("Error fetching %s [%s]", name, res.Err.Error())
+		return false, http.StatusBadGateway, "Unable to fetch " + name + " details"
+	}
+	if res.StatusCode != http.StatusOK {
+		a.Log.Info().Msgf("Fetching %s returned status code [%d]", name, res.StatusCode)
+		return false, http.StatusBadGateway, "Unable to fetch " + name + " details"
+	}
+	return true, http.StatusOK, ""
+}
+
+// ----------------------------------------------------------------------------
+
+// checkAuctionWinner makes sure the review is for a finished auction, that the
+// item was one of its lots, that the seller owns both and that the reviewer
+// actually won the lot. each failure gets its own status code
+func checkAuctionWinner(rv *Review, item *Item, auction *Auction) (bool, int, string) {
+
+	if auction.Status != auctionFinished {
+		return false, http.StatusConflict, "Auction has not finished"
+	}
+
+	itemId := rv.ItemId.String()
+	isLot := false
+	for _, lot := range auction.Lots {
+		if strings.EqualFold(lot, itemId) {
+			isLot = true
+			break
+		}
+	}
+	if !isLot {
+		return false, http.StatusUnprocessableEntity, "Item is not a lot in this auction"
+	}
 
-	// query for averages and count for the seller
-	err := a.DB.Model(&Review{}).
-		Select("COUNT(*) as review_count, AVG(overall) as overall_average, AVG(pap_cost) as pap_cost_average, AVG(comm) as comm_average, AVG(as_desc) as as_desc_average").
-		Where("seller = ?", sellerId).
-		Scan(&avgs).Error
+	seller := rv.Seller.String()
+	if !strings.EqualFold(item.PublicId, seller) || !strings.EqualFold(auction.PublicId, seller) {
+		return false, http.StatusBadRequest, "Seller doesn't match auction owner"
+	}
 
+	for _, w := range auction.Winners {
+		if strings.EqualFold(w.ItemId, itemId) && strings.EqualFold(w.PublicId, rv.ReviewedBy.String()) {
+			return true, http.StatusOK, ""
+		}
+	}
+	return false, http.StatusForbidden, "Reviewer did not win this lot"
+}
+
+// ----------------------------------------------------------------------------
+
+// existingReviewId returns the id of any review already left by the reviewer
+// for the same auction item, deleted or not, or uuid.Nil if there isn't one
+func (a *App) existingReviewId(rv *Review) (uuid.UUID, error) {
+
+	// deleted reviews still count so they can't be posted again
+	var existing Review
+	res := a.DB.Unscoped().Select("review_id").
+		Where("reviewed_by = ? AND auction_id = ? AND item_id = ?", rv.ReviewedBy, rv.AuctionId, rv.ItemId).
+		Limit(1).
+		Find(&existing)
+	if res.Error != nil {
+		return uuid.Nil, res.Error
+	}
+	return existing.ReviewId, nil
+}
+
+// ----------------------------------------------------------------------------
+
+// GetSellersScores gets the scores for a set of sellers from their running
+// totals, fetched in one query. each seller is shrunk towards the totals of
+// every other seller. sellers with no reviews get empty scores
+func (a *App) GetSellersScores(sellerIds []uuid.UUID) (map[string]Scores, error) {
+
+	scores := make(map[string]Scores, len(sellerIds))
+	if len(sellerIds) == 0 {
+		return scores, nil
+	}
+
+	var sss []SellerScore
+	if err := a.DB.Where("seller IN ?", sellerIds).Find(&sss).Error; err != nil {
+		return nil, err
+	}
+	bySeller := make(map[uuid.UUID]SellerScore, len(sss))
+	for _, ss := range sss {
+		bySeller[ss.Seller] = ss
+	}
+
+	platform, err := a.GetPlatformTotals()
 	if err != nil {
-		return Scores{}, err
+		return nil, err
+	}
+
+	pw := scorePriorWeight()
+	for _, id := range sellerIds {
+		avgs := bySeller[id].averages()
+		s := scoresFromAverages(avgs)
+		s.Bayesian = bayesianScores(avgs, platform.without(bySeller[id]).averages(), pw)
+		scores[id.String()] = s
+	}
+	return scores, nil
+}
+
+// ----------------------------------------------------------------------------
+
+// GetPlatformTotals adds up the running totals of every seller. take a
+// seller's own totals off with without before using it as their prior
+func (a *App) GetPlatformTotals() (SellerScore, error) {
+	var ss SellerScore
+
+	err := a.DB.Model(&SellerScore{}).
+		Select("COALESCE(SUM(review_count), 0) as review_count, " +
+			"COALESCE(SUM(overall_sum), 0) as overall_sum, " +
+			"COALESCE(SUM(pap_cost_sum), 0) as pap_cost_sum, " +
+			"COALESCE(SUM(comm_sum), 0) as comm_sum, " +
+			"COALESCE(SUM(as_desc_sum), 0) as as_desc_sum").
+		Scan(&ss).Error
+
+	return ss, err
+}
+
+// ----------------------------------------------------------------------------
+
+// newDistribution returns a distribution with a zero count for every score
+func newDistribution() Distribution {
+	return Distribution{
+		Overall: scoreHistogram(),
+		PapCost: scoreHistogram(),
+		Comm:    scoreHistogram(),
+		AsDesc:  scoreHistogram(),
 	}
+}
+
+// add counts a row of score_counts in the histogram for its dimension
+func (d Distribution) add(sc ScoreCount) {
+	switch sc.Dimension {
+	case "overall":
+		addToHistogram(d.Overall, sc.Score, sc.Total)
+	case "post_and_packaging":
+		addToHistogram(d.PapCost, sc.Score, sc.Total)
+	case "communication":
+		addToHistogram(d.Comm, sc.Score, sc.Total)
+	case "as_described":
+		addToHistogram(d.AsDesc, sc.Score, sc.Total)
+	}
+}
 
-	a.Log.Debug().Interface("ReviewAverages", avgs).Send()
+// ----------------------------------------------------------------------------
 
-	// if fewer than 3 reviews, return zeroes
-	if avgs.ReviewCount < 3 {
-		return Scores{}, nil
+// scoreHistogram returns a map with a zero count for every score on our scale
+func scoreHistogram() map[int]int64 {
+	h := make(map[int]int64, maxScore-minScore+1)
+	for i := minScore; i <= maxScore; i++ {
+		h[i] = 0
 	}
+	return h
+}
+
+// addToHistogram counts n reviews with the score. scores from before they
+// were validated can be off our scale so they're clamped to the nearest end
+// of it rather than adding extra keys
+func addToHistogram(h map[int]int64, score int, n int64) {
+	h[clampScore(score)] += n
+}
+
+func clampScore(score int) int {
+	return max(minScore, min(maxScore, score))
+}
+
+// ----------------------------------------------------------------------------
+
+func scoresFromAverages(avgs ReviewAverages) Scores {
 
 	metaAverage := (avgs.OverallAverage + avgs.PapCostAverage + avgs.CommAverage + avgs.AsDescAverage) / 4
 
@@ -198,7 +476,99 @@ func (a *App) GetSellerScores(sellerId uuid.UUID) (Scores, error) {
 		PapCostAverage: roundFloat(avgs.PapCostAverage, 2),
 		CommAverage:    roundFloat(avgs.CommAverage, 2),
 		AsDescAverage:  roundFloat(avgs.AsDescAverage, 2),
-	}, nil
+		ReviewCount:    avgs.ReviewCount,
+	}
+}
+
+// ----------------------------------------------------------------------------
+
+// bayesianScores shrinks a seller's averages towards the platform averages.
+// it's as if every seller starts with priorWeight reviews at the platform
+// average so a handful of reviews can't outrank a long track record
+func bayesianScores(avgs, prior ReviewAverages, priorWeight float32) *BayesianScores {
+
+	// with no other reviews on the platform there's nothing to shrink
+	// towards so the middle of the scale is used instead of zeros
+	if prior.ReviewCount == 0 {
+		mid := float32(minScore+maxScore) / 2
+		prior = ReviewAverages{OverallAverage: mid, PapCostAverage: mid, CommAverage: mid, AsDescAverage: mid}
+	}
+
+	n := float32(avgs.ReviewCount)
+	shrink := func(avg, priorAvg float32) float32 {
+		if n+priorWeight == 0 {
+			return 0
+		}
+		return (priorWeight*priorAvg + n*avg) / (priorWeight + n)
+	}
+
+	bs := BayesianScores{
+		OverallAverage: shrink(avgs.OverallAverage, prior.OverallAverage),
+		PapCostAverage: shrink(avgs.PapCostAverage, prior.PapCostAverage),
+		CommAverage:    shrink(avgs.CommAverage, prior.CommAverage),
+		AsDescAverage:  shrink(avgs.AsDescAverage, prior.AsDescAverage),
+		PriorWeight:    priorWeight,
+	}
+	bs.MetaAverage = (bs.OverallAverage + bs.PapCostAverage + bs.CommAverage + bs.AsDescAverage) / 4
+	if n+priorWeight > 0 {
+		bs.Confidence = n / (n + priorWeight)
+	}
+
+	bs.MetaAverage = roundFloat(bs.MetaAverage, 2)
+	bs.OverallAverage = roundFloat(bs.OverallAverage, 2)
+	bs.PapCostAverage = roundFloat(bs.PapCostAverage, 2)
+	bs.CommAverage = roundFloat(bs.CommAverage, 2)
+	bs.AsDescAverage = roundFloat(bs.AsDescAverage, 2)
+	bs.Confidence = roundFloat(bs.Confidence, 2)
+
+	return &bs
+}
+
+// ----------------------------------------------------------------------------
+
+// scorePriorWeight returns how many platform average reviews each seller
+// is assumed to start with. defaults to 10 if not set or not valid
+func scorePriorWeight() float32 {
+	pw, err := strconv.ParseFloat(os.Getenv("SCORE_PRIOR_WEIGHT"), 32)
+	if err != nil || pw < 0 {
+		return defaultPriorWeight
+	}
+	return float32(pw)
+}
+
+// ----------------------------------------------------------------------------
+
+// scoreHalfLife returns the number of days it takes for a review's weight
+// to halve. defaults to 180 days if not set or not valid
+func scoreHalfLife() float64 {
+	hl, err := strconv.ParseFloat(os.Getenv("SCORE_HALFLIFE_DAYS"), 64)
+	if err != nil || hl <= 0 {
+		return defaultHalfLifeDays
+	}
+	return hl
+}
+
+// ----------------------------------------------------------------------------
+
+// editWindow returns how long after creation a review can be edited.
+// defaults to 48 hours if not set or not valid
+func editWindow() time.Duration {
+	hrs, err := strconv.ParseFloat(os.Getenv("EDIT_WINDOW_HOURS"), 64)
+	if err != nil || hrs < 0 {
+		hrs = defaultEditWindowHours
+	}
+	return time.Duration(hrs * float64(time.Hour))
+}
+
+// ----------------------------------------------------------------------------
+
+// envInt returns an env var as an int or def if it isn't set or valid
+func envInt(name string, def int) int {
+	i, err := strconv.Atoi(os.Getenv(name))
+	if err != nil {
+		return def
+	}
+	return i
 }
 
 // ----------------------------------------------------------------------------
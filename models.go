@@ -2,21 +2,222 @@ package main
 
 import (
 	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"strings"
 	"time"
 )
 
 type Review struct {
 	ReviewId   uuid.UUID `gorm:"type:uuid;primaryKey" json:"review_id"`
 	Review     string    `gorm:"type:varchar(2000)" json:"review"`
-	ReviewedBy uuid.UUID `gorm:"type:uuid;index" json:"reviewed_by" binding:"required"` // PublicId of reviewer
-	AuctionId  uuid.UUID `gorm:"type:uuid;index" json:"auction_id" binding:"required"`
-	ItemId     uuid.UUID `gorm:"type:uuid;index" json:"item_id" binding:"required"`
-	Seller     uuid.UUID `gorm:"type:uuid;index" json:"seller" binding:"required"` // PublicId of seller
-	Overall    int       `json:"overall" binding:"required"`
-	PapCost    int       `json:"post_and_packaging" binding:"required"`
-	Comm       int       `json:"communication" binding:"required"`
-	AsDesc     int       `json:"as_described" binding:"required"`
+	ReviewedBy uuid.UUID `gorm:"type:uuid;index;uniqueIndex:idx_reviewer_auction_item" json:"reviewed_by"` // PublicId of reviewer
+	AuctionId  uuid.UUID `gorm:"type:uuid;index;uniqueIndex:idx_reviewer_auction_item" json:"auction_id"`
+	ItemId     uuid.UUID `gorm:"type:uuid;index;uniqueIndex:idx_reviewer_auction_item" json:"item_id"`
+	Seller     uuid.UUID `gorm:"type:uuid;index" json:"seller"` // PublicId of seller
+	Overall    int       `json:"overall"`
+	PapCost    int       `json:"post_and_packaging"`
+	Comm       int       `json:"communication"`
+	AsDesc     int       `json:"as_described"`
 	Created    time.Time `gorm:"autoCreateTime" json:"created"`
+	// reviews are soft deleted so they can't be deleted and posted again
+	DeletedAt     gorm.DeletedAt `gorm:"index" json:"-" faker:"-"`
+	Deleted       *time.Time     `gorm:"-" json:"deleted_at,omitempty" faker:"-"` // DeletedAt if the review is deleted
+	DeletedReason string         `gorm:"type:varchar(500)" json:"deleted_reason,omitempty" faker:"-"`
+	DeletedBy     *uuid.UUID     `gorm:"type:uuid" json:"deleted_by,omitempty" faker:"-"` // PublicId of deleter
+	// admins can hide reviews which leaves them out of lists and scores
+	Hidden       bool       `gorm:"not null;default:false;index" json:"hidden,omitempty" faker:"-"`
+	HiddenReason string     `gorm:"type:varchar(500)" json:"hidden_reason,omitempty" faker:"-"`
+	HiddenBy     *uuid.UUID `gorm:"type:uuid" json:"hidden_by,omitempty" faker:"-"` // PublicId of admin
+	// reviews can be edited for a while after they are created
+	Edited   bool       `gorm:"not null;default:false" json:"edited" faker:"-"`
+	EditedAt *time.Time `json:"edited_at,omitempty" faker:"-"`
+	// any change at all, including hiding and replies, for Last-Modified
+	Updated time.Time `gorm:"autoUpdateTime;not null;default:now()" json:"-" faker:"-"`
+	// matching text from a search, it isn't a column
+	Snippet string `gorm:"->;-:migration" json:"snippet,omitempty" faker:"-"`
+	// name of the service that posted a system review, empty for users
+	PostedBy string `gorm:"type:varchar(50)" json:"posted_by,omitempty" faker:"-"`
+	// the seller's reply lives in its own table and is added when listing
+	Reply *ReviewReply `gorm:"-" json:"reply,omitempty" faker:"-"`
+}
+
+// AfterFind sets Deleted so deleted_at is only in the json of reviews that
+// have actually been deleted
+func (rv *Review) AfterFind(tx *gorm.DB) error {
+	rv.setDeleted()
+	return nil
+}
+
+func (rv *Review) setDeleted() {
+	rv.Deleted = nil
+	if rv.DeletedAt.Valid {
+		t := rv.DeletedAt.Time
+		rv.Deleted = &t
+	}
+}
+
+// ReviewReply is the seller's public reply to a review of them. there can
+// only be one reply per review
+type ReviewReply struct {
+	ReplyId  uuid.UUID `gorm:"type:uuid;primaryKey" json:"reply_id"`
+	ReviewId uuid.UUID `gorm:"type:uuid;uniqueIndex" json:"review_id"`
+	Seller   uuid.UUID `gorm:"type:uuid;index" json:"seller"` // PublicId of seller
+	Reply    string    `gorm:"type:varchar(2000)" json:"reply"`
+	Created  time.Time `gorm:"autoCreateTime" json:"created"`
+}
+
+// BatchInput is a list of ids to fetch reviews for. By is review (the
+// default), auction or item
+type BatchInput struct {
+	By  string   `json:"by"`
+	Ids []string `json:"ids" binding:"required"`
+}
+
+// UsersMetadataInput is a list of public_ids to fetch metadata for
+type UsersMetadataInput struct {
+	PublicIds []string `json:"public_ids" binding:"required"`
+}
+
+type ReplyInput struct {
+	Reply string `json:"reply" binding:"required,reviewtext"`
+}
+
+// ReasonInput is the optional body sent when deleting or hiding a review
+type ReasonInput struct {
+	Reason string `json:"reason" binding:"max=500"`
+}
+
+// ReviewInput is what gets bound when a review is posted. scores are pointers
+// so that a missing score can be told apart from a score of 0
+type ReviewInput struct {
+	Review     string    `json:"review" binding:"reviewtext"`
+	ReviewedBy uuid.UUID `json:"reviewed_by" binding:"required"`
+	AuctionId  uuid.UUID `json:"auction_id" binding:"required"`
+	ItemId     uuid.UUID `json:"item_id" binding:"required"`
+	Seller     uuid.UUID `json:"seller" binding:"required,uuidne=ReviewedBy"`
+	Overall    *int      `json:"overall" binding:"required,score"`
+	PapCost    *int      `json:"post_and_packaging" binding:"required,score"`
+	Comm       *int      `json:"communication" binding:"required,score"`
+	AsDesc     *int      `json:"as_described" binding:"required,score"`
+}
+
+func (ri *ReviewInput) toReview() Review {
+	return Review{
+		Review:     strings.TrimSpace(ri.Review),
+		ReviewedBy: ri.ReviewedBy,
+		AuctionId:  ri.AuctionId,
+		ItemId:     ri.ItemId,
+		Seller:     ri.Seller,
+		Overall:    *ri.Overall,
+		PapCost:    *ri.PapCost,
+		Comm:       *ri.Comm,
+		AsDesc:     *ri.AsDesc,
+	}
+}
+
+// ReviewPatchInput is bound when a review is edited. only the fields that
+// are sent get changed
+type ReviewPatchInput struct {
+	Review  *string `json:"review" binding:"omitempty,reviewtext"`
+	Overall *int    `json:"overall" binding:"omitempty,score"`
+	PapCost *int    `json:"post_and_packaging" binding:"omitempty,score"`
+	Comm    *int    `json:"communication" binding:"omitempty,score"`
+	AsDesc  *int    `json:"as_described" binding:"omitempty,score"`
+}
+
+func (pi *ReviewPatchInput) empty() bool {
+	return pi.Review == nil && pi.Overall == nil && pi.PapCost == nil && pi.Comm == nil && pi.AsDesc == nil
+}
+
+// applyTo copies any fields that were sent onto the review
+func (pi *ReviewPatchInput) applyTo(rv *Review) {
+	if pi.Review != nil {
+		rv.Review = strings.TrimSpace(*pi.Review)
+	}
+	if pi.Overall != nil {
+		rv.Overall = *pi.Overall
+	}
+	if pi.PapCost != nil {
+		rv.PapCost = *pi.PapCost
+	}
+	if pi.Comm != nil {
+		rv.Comm = *pi.Comm
+	}
+	if pi.AsDesc != nil {
+		rv.AsDesc = *pi.AsDesc
+	}
+}
+
+// ApiKey lets another microservice call the internal routes without a user
+// access token. only the sha256 of the key is stored
+type ApiKey struct {
+	KeyId   uuid.UUID  `gorm:"type:uuid;primaryKey" json:"key_id"`
+	Service string     `gorm:"type:varchar(50);index" json:"service"`
+	KeyHash string     `gorm:"type:char(64);uniqueIndex" json:"-"`
+	Scopes  string     `gorm:"type:varchar(200)" json:"scopes"` // space separated
+	Created time.Time  `gorm:"autoCreateTime" json:"created"`
+	Revoked *time.Time `json:"revoked,omitempty"`
+}
+
+// AdminAction is an audit log entry for anything an admin does to a review
+type AdminAction struct {
+	ActionId uuid.UUID `gorm:"type:uuid;primaryKey" json:"action_id"`
+	Actor    uuid.UUID `gorm:"type:uuid;index" json:"actor"` // PublicId of admin
+	Action   string    `gorm:"type:varchar(20)" json:"action"`
+	ReviewId uuid.UUID `gorm:"type:uuid;index" json:"review_id"`
+	Reason   string    `gorm:"type:varchar(500)" json:"reason,omitempty"`
+	Created  time.Time `gorm:"autoCreateTime;index" json:"created"`
+}
+
+// ReviewRevision is a previous version of a review kept when it is edited.
+// version 1 is the review as it was first posted
+type ReviewRevision struct {
+	RevisionId uuid.UUID `gorm:"type:uuid;primaryKey" json:"revision_id"`
+	ReviewId   uuid.UUID `gorm:"type:uuid;uniqueIndex:idx_review_version" json:"review_id"`
+	Version    int       `gorm:"uniqueIndex:idx_review_version" json:"version"`
+	Review     string    `gorm:"type:varchar(2000)" json:"review"`
+	Overall    int       `json:"overall"`
+	PapCost    int       `json:"post_and_packaging"`
+	Comm       int       `json:"communication"`
+	AsDesc     int       `json:"as_described"`
+	Created    time.Time `json:"created"` // when this version was written
+	Replaced   time.Time `gorm:"autoCreateTime" json:"replaced"`
+}
+
+// defaultEditWindowHours is how long a reviewer has to edit their review
+// if EDIT_WINDOW_HOURS isn't set
+const defaultEditWindowHours = 48
+
+// SellerScore holds running totals of each seller's scores so we don't
+// have to scan all their reviews to work out their averages. WrittenCount
+// is the number of reviews the user has written of other sellers
+type SellerScore struct {
+	Seller       uuid.UUID `gorm:"type:uuid;primaryKey" json:"seller"`
+	ReviewCount  int64     `json:"review_count"`
+	OverallSum   int64     `json:"overall_sum"`
+	PapCostSum   int64     `json:"pap_cost_sum"`
+	CommSum      int64     `json:"comm_sum"`
+	AsDescSum    int64     `json:"as_desc_sum"`
+	WrittenCount int64     `gorm:"not null;default:0" json:"written_count"`
+	Updated      time.Time `gorm:"autoUpdateTime" json:"updated"`
+}
+
+// ScoreCount is how many of a seller's reviews gave each score for each
+// dimension, so the distribution is kept up to date like the totals
+type ScoreCount struct {
+	Seller    uuid.UUID `gorm:"type:uuid;primaryKey" json:"seller"`
+	Dimension string    `gorm:"type:varchar(20);primaryKey" json:"dimension"`
+	Score     int       `gorm:"primaryKey;autoIncrement:false" json:"score"`
+	Total     int64     `json:"total"`
+}
+
+type ScoreMismatch struct {
+	Seller        uuid.UUID      `json:"seller"`
+	Live          ReviewAverages `json:"live"`
+	Stored        ReviewAverages `json:"stored"`
+	LiveWritten   int64          `json:"live_written"`
+	StoredWritten int64          `json:"stored_written"`
+	Distribution  bool           `json:"distribution_differs"`
 }
 
 type ReviewsResponse struct {
@@ -25,6 +226,10 @@ type ReviewsResponse struct {
 	TotalPages  	int			`json:"total_pages"`
 	TotalReviews	int 		`json:"total_reviews"`
 	URLS			[]URL		`json:"urls"`
+	Links			*Links		`json:"links,omitempty"`
+	NextCursor		string		`json:"next_cursor,omitempty"`
+	PrevCursor		string		`json:"prev_cursor,omitempty"`
+	SellerScores	map[string]Scores	`json:"seller_scores,omitempty"`
 }
 
 type CreateReviewResp struct {
@@ -35,9 +240,20 @@ type RespMessage struct {
 	Message string `json:"message"`
 }
 
+// Links are the first, prev, next and last pages of a list. cursor pages
+// don't have a last
+type Links struct {
+	First string `json:"first,omitempty"`
+	Prev  string `json:"prev,omitempty"`
+	Next  string `json:"next,omitempty"`
+	Last  string `json:"last,omitempty"`
+}
+
 type URL struct {
 	PrevURL	string `json:"prev_url,omitempty"`
 	NextURL string `json:"next_url,omitempty"`
+	PrevCursor string `json:"prev_cursor,omitempty"`
+	NextCursor string `json:"next_cursor,omitempty"`
 }
 
 type Scores struct {
@@ -46,8 +262,31 @@ type Scores struct {
 	PapCostAverage  float32 `json:"pap_cost_average"`
 	CommAverage     float32 `json:"comm_average"`
 	AsDescAverage   float32 `json:"as_desc_average"`
+	ReviewCount     int     `json:"review_count"`
+	Bayesian        *BayesianScores `json:"bayesian,omitempty"`
+}
+
+// BayesianScores are averages shrunk towards the platform wide averages.
+// confidence runs from 0 to 1 as a seller gets more reviews
+type BayesianScores struct {
+	MetaAverage    float32 `json:"meta_average"`
+	OverallAverage float32 `json:"overall_average"`
+	PapCostAverage float32 `json:"pap_cost_average"`
+	CommAverage    float32 `json:"comm_average"`
+	AsDescAverage  float32 `json:"as_desc_average"`
+	Confidence     float32 `json:"confidence"`
+	PriorWeight    float32 `json:"prior_weight"`
 }
 
+// defaultPriorWeight is the number of platform average reviews each seller
+// is assumed to have when calculating bayesian scores. if there are no other
+// reviews they're assumed to be at the middle of the scale
+const defaultPriorWeight = 10
+
+// defaultHalfLifeDays is used when weighting scores by age if
+// SCORE_HALFLIFE_DAYS isn't set
+const defaultHalfLifeDays = 180
+
 type ReviewAverages struct {
 	ReviewCount     int
 	OverallAverage  float32
@@ -66,6 +305,14 @@ type Item struct {
 	Modified    string `json:"modified"`
 }
 
+// auctionFinished is the auction status that allows reviews to be left
+const auctionFinished = "finished"
+
+type LotWinner struct {
+	ItemId   string `json:"item_id"`
+	PublicId string `json:"public_id"`
+}
+
 type Auction struct {
 	AuctionId string `json:"auction_id"`
 	PublicId  string `json:"public_id"`
@@ -80,11 +327,29 @@ type Auction struct {
 	Created   string `json:"created"`
 	Modified  string `json:"modified"`
 	Currency  string `json:"currency"`
+	Winners   []LotWinner `json:"winners"`
+}
+
+// Distribution holds counts of each score for each dimension keyed on score
+type Distribution struct {
+	Overall map[int]int64 `json:"overall"`
+	PapCost map[int]int64 `json:"post_and_packaging"`
+	Comm    map[int]int64 `json:"communication"`
+	AsDesc  map[int]int64 `json:"as_described"`
+	Recent  RecentCounts  `json:"recent"`
+}
+
+type RecentCounts struct {
+	Last30Days  int64 `json:"last_30_days"`
+	Last90Days  int64 `json:"last_90_days"`
+	Last365Days int64 `json:"last_365_days"`
 }
 
 type MetadataResp struct {
 	PublicId           string `json:"public_id"`
 	Scores             Scores `json:"scores"`
+	WeightedScores     Scores `json:"weighted_scores"`
+	Distribution       Distribution `json:"distribution"`
 	TotalReviewsByUser int    `json:"total_reviews_by_user"`
 	TotalReviewsOfUser int    `json:"total_reviews_of_user"`
 }
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"math"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ----------------------------------------------------------------------------
+// p a g i n a t i o n   l i n k s
+// ----------------------------------------------------------------------------
+
+// InitialisePagination checks PREVNEXTURL is set as every page link is
+// built on it
+func (a *App) InitialisePagination() {
+
+	a.Log.Info().Msg("Initialising pagination")
+	if os.Getenv("PREVNEXTURL") == "" {
+		a.Log.Fatal().Msg("PREVNEXTURL must be set for pagination links")
+	}
+}
+
+// ----------------------------------------------------------------------------
+
+// CreateURLS makes the prev and next urls for the body along with first,
+// prev, next and last links which are also sent as a Link header
+func CreateURLS(c *gin.Context, page, totalPages int) ([]URL, Links) {
+
+	var urls []URL
+	links := Links{First: pageURL(c, 1), Last: pageURL(c, totalPages)}
+	if page > 1 {
+		links.Prev = pageURL(c, page-1)
+		urls = append(urls, URL{PrevURL: links.Prev})
+	}
+	if page < totalPages {
+		links.Next = pageURL(c, page+1)
+		urls = append(urls, URL{NextURL: links.Next})
+	}
+	setLinkHeader(c, links)
+	return urls, links
+}
+
+// ----------------------------------------------------------------------------
+
+func totalPagesFor(tc int64, pagesize int) int {
+	return int(math.Ceil(float64(tc) / float64(pagesize)))
+}
+
+// ----------------------------------------------------------------------------
+
+// pageURL keeps the rest of the query string, such as a search or filters,
+// and just changes the page
+func pageURL(c *gin.Context, page int) string {
+
+	q := c.Request.URL.Query()
+	q.Set("page", strconv.Itoa(page))
+	return listURL(c, q)
+}
+
+// ----------------------------------------------------------------------------
+
+// listURL makes an absolute url for the current path with a new query
+// string. the base is always PREVNEXTURL, never the Host or any forwarded
+// headers the client sent
+func listURL(c *gin.Context, q url.Values) string {
+
+	base := strings.TrimSuffix(os.Getenv("PREVNEXTURL"), "/")
+	u := url.URL{Path: c.Request.URL.Path, RawQuery: q.Encode()}
+	return base + u.String()
+}
+
+// ----------------------------------------------------------------------------
+
+// setLinkHeader sends the links as an rfc 8288 Link header
+func setLinkHeader(c *gin.Context, links Links) {
+
+	var parts []string
+	for _, l := range []struct{ rel, url string }{
+		{"first", links.First},
+		{"prev", links.Prev},
+		{"next", links.Next},
+		{"last", links.Last},
+	} {
+		if l.url != "" {
+			parts = append(parts, "<"+l.url+`>; rel="`+l.rel+`"`)
+		}
+	}
+	if len(parts) > 0 {
+		c.Header("Link", strings.Join(parts, ", "))
+	}
+}
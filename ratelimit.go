@@ -0,0 +1,272 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ----------------------------------------------------------------------------
+// r a t e   l i m i t i n g
+// ----------------------------------------------------------------------------
+
+// rateLimit is a token bucket that holds up to Burst tokens and refills at
+// PerMin tokens a minute. a PerMin of 0 turns the limit off
+type rateLimit struct {
+	PerMin int
+	Burst  int
+}
+
+// defaultRateLimits are used for any group whose RATE_LIMIT_<GROUP> env var
+// isn't set. the env var is "<per minute>,<burst>"
+var defaultRateLimits = map[string]rateLimit{
+	"auth":     {PerMin: 300, Burst: 100},
+	"public":   {PerMin: 120, Burst: 60},
+	"authed":   {PerMin: 120, Burst: 60},
+	"write":    {PerMin: 20, Burst: 10},
+	"internal": {PerMin: 1200, Burst: 600},
+}
+
+type rateResult struct {
+	Allowed    bool
+	Remaining  int
+	RetryAfter time.Duration // until the next token if not allowed
+	Reset      time.Duration // until the bucket is full again
+}
+
+// rateLimitStore keeps the buckets. the memory store is fine while we run a
+// single instance, a shared store such as redis only needs these methods.
+// Take takes n tokens or none at all
+type rateLimitStore interface {
+	Take(key string, lim rateLimit, n int) (rateResult, error)
+	Reset() error
+}
+
+type rateLimiter struct {
+	store  rateLimitStore
+	limits map[string]rateLimit
+}
+
+// ----------------------------------------------------------------------------
+
+// InitialiseRateLimiter reads the limits for each route group
+func (a *App) InitialiseRateLimiter() {
+
+	a.Log.Info().Msg("Initialising rate limiter")
+	limits := make(map[string]rateLimit, len(defaultRateLimits))
+	for group, def := range defaultRateLimits {
+		name := "RATE_LIMIT_" + strings.ToUpper(group)
+		lim, err := parseRateLimit(os.Getenv(name), def)
+		if err != nil {
+			a.Log.Error().Msgf("Invalid %s [%s], using default", name, err.Error())
+		}
+		limits[group] = lim
+	}
+	a.RateLimiter = &rateLimiter{store: newMemoryRateStore(), limits: limits}
+}
+
+// ----------------------------------------------------------------------------
+
+func parseRateLimit(s string, def rateLimit) (rateLimit, error) {
+
+	if s == "" {
+		return def, nil
+	}
+	parts := strings.Split(s, ",")
+	perMin, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil || perMin < 0 {
+		return def, fmt.Errorf("bad rate [%s]", parts[0])
+	}
+	burst := perMin
+	if len(parts) > 1 {
+		burst, err = strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil || burst < 1 {
+			return def, fmt.Errorf("bad burst [%s]", parts[1])
+		}
+	}
+	return rateLimit{PerMin: perMin, Burst: burst}, nil
+}
+
+// ----------------------------------------------------------------------------
+
+// rateLimit limits requests in a route group. callers are counted by their
+// public_id if they've logged in, by service for internal routes and by ip
+// address otherwise
+func (a *App) rateLimit(group string) gin.HandlerFunc {
+	return a.limitBy(group, rateLimitKey)
+}
+
+// rateLimitByIP always counts callers by ip address. it goes in front of
+// authRequired and apiKeyRequired so bad tokens and keys are limited before
+// we check them
+func (a *App) rateLimitByIP(group string) gin.HandlerFunc {
+	return a.limitBy(group, func(c *gin.Context) string { return "ip:" + c.ClientIP() })
+}
+
+// ----------------------------------------------------------------------------
+
+func (a *App) limitBy(group string, keyOf func(*gin.Context) string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if a.takeRateLimit(c, group, keyOf(c), 1) {
+			c.Next()
+		}
+	}
+}
+
+// ----------------------------------------------------------------------------
+
+// chargeRateLimit takes n more tokens from the caller's bucket for group.
+// it's for handlers that do n requests worth of work in one, such as a batch
+// that checks every id with authy. it's false if the 429 has been sent
+func (a *App) chargeRateLimit(c *gin.Context, group string, n int) bool {
+	return a.takeRateLimit(c, group, rateLimitKey(c), n)
+}
+
+// ----------------------------------------------------------------------------
+
+func (a *App) takeRateLimit(c *gin.Context, group, key string, n int) bool {
+
+	rl := a.RateLimiter
+	if rl == nil || n <= 0 {
+		return true
+	}
+	lim, ok := rl.limits[group]
+	if !ok || lim.PerMin == 0 {
+		return true
+	}
+
+	key = group + ":" + key
+	res, err := rl.store.Take(key, lim, n)
+	if err != nil {
+		// if the store is broken we'd rather let people in
+		a.Log.Error().Msgf("Rate limit store error [%s]", err.Error())
+		return true
+	}
+
+	// the limit is the rate, a client can't get more than that over a
+	// minute however it spends its burst
+	c.Header("X-RateLimit-Limit", strconv.Itoa(lim.PerMin))
+	c.Header("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	c.Header("X-RateLimit-Reset", strconv.Itoa(ceilSecs(res.Reset)))
+	if !res.Allowed {
+		a.Log.Info().Msgf("Rate limit [%s] hit by [%s]", group, key)
+		c.Header("Retry-After", strconv.Itoa(ceilSecs(res.RetryAfter)))
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"message": "Too many requests"})
+		return false
+	}
+	return true
+}
+
+// ----------------------------------------------------------------------------
+
+func rateLimitKey(c *gin.Context) string {
+
+	if pId := currentPublicId(c); pId != "" {
+		return "user:" + pId
+	}
+	if v, ok := c.Get(serviceKey); ok {
+		return "service:" + v.(service).Name
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// ----------------------------------------------------------------------------
+
+func ceilSecs(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// ----------------------------------------------------------------------------
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	full   time.Time // when the bucket will have filled up again
+}
+
+// memoryRateStore keeps the buckets in a map. buckets that have filled up
+// again are swept out every so often so the map doesn't grow forever
+type memoryRateStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+const rateSweepInterval = time.Minute
+
+// ----------------------------------------------------------------------------
+
+func newMemoryRateStore() *memoryRateStore {
+	return &memoryRateStore{buckets: map[string]*bucket{}, lastSweep: time.Now(), now: time.Now}
+}
+
+// ----------------------------------------------------------------------------
+
+func (ms *memoryRateStore) Take(key string, lim rateLimit, n int) (rateResult, error) {
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	now := ms.now()
+	perSec := float64(lim.PerMin) / 60
+	if now.Sub(ms.lastSweep) > rateSweepInterval {
+		ms.sweep(now)
+	}
+
+	b, ok := ms.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(lim.Burst), last: now}
+		ms.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(lim.Burst), b.tokens+now.Sub(b.last).Seconds()*perSec)
+	b.last = now
+
+	// more than the burst could never be taken so it takes a full bucket
+	cost := math.Min(float64(n), float64(lim.Burst))
+	var res rateResult
+	if b.tokens >= cost {
+		b.tokens -= cost
+		res.Allowed = true
+	} else {
+		res.RetryAfter = secs((cost - b.tokens) / perSec)
+	}
+	res.Remaining = int(b.tokens)
+	res.Reset = secs((float64(lim.Burst) - b.tokens) / perSec)
+	b.full = now.Add(res.Reset)
+	return res, nil
+}
+
+// sweep must be called with the lock held. a bucket that has filled up is
+// the same as no bucket so it can be dropped
+func (ms *memoryRateStore) sweep(now time.Time) {
+
+	ms.lastSweep = now
+	for k, b := range ms.buckets {
+		if now.After(b.full) {
+			delete(ms.buckets, k)
+		}
+	}
+}
+
+// ----------------------------------------------------------------------------
+
+func (ms *memoryRateStore) Reset() error {
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.buckets = map[string]*bucket{}
+	return nil
+}
+
+// ----------------------------------------------------------------------------
+
+func secs(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
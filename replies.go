@@ -0,0 +1,229 @@
+package main
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ----------------------------------------------------------------------------
+// s e l l e r   r e p l i e s
+// ----------------------------------------------------------------------------
+
+var errNoReply = errors.New("no reply found")
+
+func (a *App) createReply(c *gin.Context) {
+
+	a.Log.Debug().Msg("In createReply")
+
+	publicId := currentPublicId(c)
+
+	rId, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		a.Log.Info().Msgf("Not a uuid string: [%s]", err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Not a uuid string"})
+		return
+	}
+
+	var ri ReplyInput
+	if err = c.ShouldBindJSON(&ri); err != nil {
+		a.Log.Info().Msgf("Input data does not match reply: [%s]", err.Error())
+		badInput(c, err)
+		return
+	}
+	ri.Reply = strings.TrimSpace(ri.Reply)
+	if ri.Reply == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Input data is incorrect",
+			"errors": []FieldError{{Field: "reply", Message: "reply is required"}}})
+		return
+	}
+
+	b, st, mess := a.checkReviewSeller(rId, publicId)
+	if !b {
+		c.JSON(st, gin.H{"message": mess})
+		return
+	}
+
+	seller, _ := uuid.Parse(publicId)
+	replyId, _ := uuid.NewRandom()
+	rp := ReviewReply{
+		ReplyId:  replyId,
+		ReviewId: rId,
+		Seller:   seller,
+		Reply:    ri.Reply,
+	}
+	err = a.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&rp).Error; err != nil {
+			return err
+		}
+		return touchReview(tx, rId)
+	})
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		a.Log.Info().Msgf("Reply already exists for review [%s]", rId.String())
+		existing, ferr := a.fetchReply(rId)
+		if ferr != nil || existing == nil {
+			c.JSON(http.StatusConflict, gin.H{"message": "Reply already exists"})
+			return
+		}
+		c.JSON(http.StatusConflict, gin.H{"message": "Reply already exists", "reply_id": existing.ReplyId})
+		return
+	}
+	if err != nil {
+		a.Log.Info().Msgf("Reply creation failed: [%s]", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Something went bang."})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"reply_id": replyId})
+}
+
+// ----------------------------------------------------------------------------
+
+func (a *App) getReply(c *gin.Context) {
+
+	rId, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		a.Log.Info().Msgf("Not a uuid string: [%s]", err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Not a uuid string"})
+		return
+	}
+
+	// replies to deleted or hidden reviews are hidden along with the review
+	var tc int64
+	a.DB.Model(&Review{}).Scopes(notHidden).Where("review_id = ?", rId).Count(&tc)
+	if tc == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Review not found"})
+		return
+	}
+
+	rp, err := a.fetchReply(rId)
+	if err != nil {
+		a.Log.Info().Msgf("Error fetching reply: [%s]", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Something went bang"})
+		return
+	}
+	if rp == nil {
+		c.JSON(http.StatusNotFound, gin.H{"message": "No reply found"})
+		return
+	}
+
+	// replies touch their review so it changes with them
+	a.setLastModified(c, "review_id = ?", rId)
+	c.JSON(http.StatusOK, rp)
+}
+
+// ----------------------------------------------------------------------------
+
+func (a *App) deleteReply(c *gin.Context) {
+
+	publicId := currentPublicId(c)
+
+	rId, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		a.Log.Info().Msgf("Not a uuid string: [%s]", err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Not a uuid string"})
+		return
+	}
+
+	b, st, mess := a.checkReviewSeller(rId, publicId)
+	if !b {
+		c.JSON(st, gin.H{"message": mess})
+		return
+	}
+
+	err = a.DB.Transaction(func(tx *gorm.DB) error {
+		res := tx.Where("review_id = ? AND seller = ?", rId, publicId).Delete(&ReviewReply{})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errNoReply
+		}
+		return touchReview(tx, rId)
+	})
+	if errors.Is(err, errNoReply) {
+		c.JSON(http.StatusNotFound, gin.H{"message": "No reply found"})
+		return
+	}
+	if err != nil {
+		a.Log.Info().Msgf("Error deleting reply [%s]", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Something went pop"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"reply_deleted": rId})
+}
+
+// ----------------------------------------------------------------------------
+
+// checkReviewSeller checks the review exists and that it was written about
+// the logged in user as only they can reply to it
+func (a *App) checkReviewSeller(rId uuid.UUID, publicId string) (bool, int, string) {
+
+	var rv Review
+	res := a.DB.Scopes(notHidden).Where("review_id = ?", rId).Limit(1).Find(&rv)
+	if res.Error != nil {
+		a.Log.Info().Msgf("Error fetching review: [%s]", res.Error.Error())
+		return false, http.StatusInternalServerError, "Something went bang"
+	}
+	if res.RowsAffected == 0 {
+		return false, http.StatusNotFound, "Review not found"
+	}
+	if !strings.EqualFold(rv.Seller.String(), publicId) {
+		a.Log.Info().Msgf("User [%s] is not the seller for review [%s]", publicId, rId.String())
+		return false, http.StatusForbidden, "Only the seller can reply to a review"
+	}
+	return true, http.StatusOK, ""
+}
+
+// ----------------------------------------------------------------------------
+
+func (a *App) fetchReply(rId uuid.UUID) (*ReviewReply, error) {
+
+	var rp ReviewReply
+	res := a.DB.Where("review_id = ?", rId).Limit(1).Find(&rp)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, nil
+	}
+	return &rp, nil
+}
+
+// ----------------------------------------------------------------------------
+
+// attachReplies fetches the replies for a page of reviews in one query
+func (a *App) attachReplies(reviews []Review) error {
+
+	ids := make([]uuid.UUID, len(reviews))
+	for i, rv := range reviews {
+		ids[i] = rv.ReviewId
+	}
+
+	var replies []ReviewReply
+	if err := a.DB.Where("review_id IN ?", ids).Find(&replies).Error; err != nil {
+		return err
+	}
+
+	byReview := make(map[uuid.UUID]*ReviewReply, len(replies))
+	for i := range replies {
+		byReview[replies[i].ReviewId] = &replies[i]
+	}
+	for i := range reviews {
+		reviews[i].Reply = byReview[reviews[i].ReviewId]
+	}
+	return nil
+}
+
+// ----------------------------------------------------------------------------
+
+// touchReview moves a review's updated time on when something shown with
+// it, such as its reply, changes
+func touchReview(tx *gorm.DB, rId uuid.UUID) error {
+	return tx.Model(&Review{}).Where("review_id = ?", rId).Update("updated", time.Now()).Error
+}
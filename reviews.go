@@ -64,6 +64,15 @@ func main() {
 
 	a := App{}
 	a.Log = &logger
+
+	// run a maintenance command instead of the server if we're given one
+	if len(os.Args) > 1 {
+		a.InitialiseDatabase()
+		code := a.RunCommand(os.Args[1:])
+		_ = logFile.Close()
+		os.Exit(code)
+	}
+
 	a.InitialiseApp()
 	a.Run(":" + os.Getenv("PORT"))
 
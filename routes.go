@@ -4,51 +4,82 @@ import (
 	"github.com/gin-gonic/gin"
 	"net/http"
 	"os"
+	"strings"
 )
 
 func (a *App) InitialiseRoutes() {
 
 	a.Log.Info().Msg("Initialising routes")
 
+	// only proxies we know about can set the client ip with X-Forwarded-For
+	// or the ip rate limits could be dodged by sending a made up one
+	if err := a.Router.SetTrustedProxies(trustedProxies()); err != nil {
+		a.Log.Fatal().Msgf("Invalid TRUSTED_PROXIES [%s]", err.Error())
+	}
+
+	// every request gets a request id
+	a.Router.Use(a.requestId())
+
 	a.Router.GET("/reviews/status", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"message": "System running...", "version": os.Getenv("VERSION")})
 	})
 
-	a.Router.GET("/reviews", func(c *gin.Context) {
-		a.getAllMyReviews(c)
-	})
+	// public routes only need to be json
+	public := a.Router.Group("/reviews", jsonOnly(), a.rateLimit("public"))
 
-	a.Router.GET("/reviews/:id", func(c *gin.Context) {
-		a.getReview(c)
-	})
+	public.GET("/search", a.httpCaching("search"), a.searchReviews)
+	public.GET("/batch", a.httpCaching("list"), a.getBatch)
+	public.POST("/batch", a.postBatch)
+	public.POST("/users/metadata", a.getUsersMetadata)
 
-	a.Router.DELETE("/reviews/:id", func(c *gin.Context) {
-		a.deleteReview(c)
-	})
+	// admins can see deleted reviews in these if they send a token
+	public.GET("/:id", a.httpCaching("review"), a.optionalAuth(), a.getReview)
+	public.GET("/item/:id", a.httpCaching("list"), a.optionalAuth(), a.getReviewsByItem)
+	public.GET("/auction/:id", a.httpCaching("list"), a.optionalAuth(), a.getReviewsByAuction)
+	public.GET("/of/user/:id", a.httpCaching("list"), a.optionalAuth(), a.getAllReviewsAboutUser)
+	public.GET("/by/user/:id", a.httpCaching("list"), a.optionalAuth(), a.getAllReviewsByUser)
 
-	a.Router.GET("/reviews/item/:id", func(c *gin.Context) {
-		a.getReviewsByItem(c)
-	})
+	public.GET("/:id/reply", a.httpCaching("review"), a.getReply)
+	public.GET("/user/:id", a.httpCaching("metadata"), a.getMetadataOfUser)
 
-	a.Router.GET("/reviews/auction/:id", func(c *gin.Context) {
-		a.getReviewsByAuction(c)
-	})
+	// authenticated routes have the user in the context
+	authed := a.Router.Group("/reviews", jsonOnly(), a.rateLimitByIP("auth"), a.authRequired(), a.rateLimit("authed"))
 
-	a.Router.GET("/reviews/of/user/:id", func(c *gin.Context) {
-		a.getAllReviewsAboutUser(c)
-	})
+	authed.GET("", a.getAllMyReviews)
+	authed.POST("", a.rateLimit("write"), a.createReview)
+	authed.DELETE("/:id", a.rateLimit("write"), a.deleteReview)
+	authed.PATCH("/:id", a.rateLimit("write"), a.editReview)
+	authed.POST("/:id/reply", a.rateLimit("write"), a.createReply)
+	authed.DELETE("/:id/reply", a.rateLimit("write"), a.deleteReply)
 
-	a.Router.GET("/reviews/by/user/:id", func(c *gin.Context) {
-		a.getAllReviewsByUser(c)
-		//a.fetchReviewsByUUID(c, "reviewed_by", c.Param("id"))
-	})
+	// internal routes for other microservices. they use an api key instead
+	// of a user's access token
+	internal := a.Router.Group("/reviews/internal", jsonOnly(), a.rateLimitByIP("internal"))
 
-	a.Router.GET("/reviews/user/:id", func(c *gin.Context) {
-		a.getMetadataOfUser(c)
-	})
+	internal.GET("/user/:id", a.apiKeyRequired(scopeReadScores), a.rateLimit("internal"), a.getInternalScores)
+	internal.POST("", a.apiKeyRequired(scopeSystemReview), a.rateLimit("internal"), a.createSystemReview)
 
-	a.Router.POST("/reviews", func(c *gin.Context) {
-		a.createReview(c)
-	})
+	// moderation routes for admins only
+	admin := authed.Group("/admin", a.adminOnly())
+
+	admin.GET("/audit", a.adminGetAudit)
+	admin.GET("/status", a.adminGetStatus)
+	admin.GET("/:id", a.adminGetReview)
+	admin.POST("/:id/hide", a.adminHideReview)
+	admin.POST("/:id/unhide", a.adminUnhideReview)
+	admin.DELETE("/:id", a.adminDeleteReview)
+}
+
+// ----------------------------------------------------------------------------
 
-}
\ No newline at end of file
+// trustedProxies reads the comma separated TRUSTED_PROXIES. none are trusted
+// if it isn't set so the client ip is always the remote address
+func trustedProxies() []string {
+	var proxies []string
+	for _, p := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			proxies = append(proxies, p)
+		}
+	}
+	return proxies
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"html"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// ----------------------------------------------------------------------------
+// f u l l   t e x t   s e a r c h
+// ----------------------------------------------------------------------------
+
+// maxSearchLength stops silly long searches
+const maxSearchLength = 200
+
+// the search has to use the same expression as idx_reviews_search so
+// postgres can use the index
+const (
+	searchVector = "to_tsvector('english', review)"
+	searchQuery  = "websearch_to_tsquery('english', ?)"
+)
+
+// ts_headline marks matches with these control characters so the review
+// text can be escaped before the marks are turned into html
+const (
+	markStart = "\x02"
+	markStop  = "\x03"
+)
+
+var headlineOptions = `StartSel="` + markStart + `", StopSel="` + markStop +
+	`", MaxWords=35, MinWords=15, MaxFragments=2, FragmentDelimiter=" ... "`
+
+// ----------------------------------------------------------------------------
+
+// CreateSearchIndex adds the gin index used by searches. gorm can't make an
+// index on an expression so it's done here after migrating
+func (a *App) CreateSearchIndex() error {
+	return a.DB.Exec("CREATE INDEX IF NOT EXISTS idx_reviews_search ON reviews USING gin (" + searchVector + ")").Error
+}
+
+// ----------------------------------------------------------------------------
+
+// searchReviews finds reviews whose text matches q. q can use quotes for
+// phrases, or and - to leave words out. results are best match first
+func (a *App) searchReviews(c *gin.Context) {
+
+	q := strings.TrimSpace(c.Query("q"))
+	if q == "" || utf8.RuneCountInString(q) > maxSearchLength {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Search must be between 1 and 200 characters"})
+		return
+	}
+
+	filters, err := parseReviewFilters(c)
+	if err != nil {
+		a.Log.Info().Msgf("Bad filter: [%s]", err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	db := a.DB.Scopes(notHidden, filters.Filter).Where(searchVector+" @@ "+searchQuery, q)
+	for _, k := range []string{"seller", "reviewed_by"} {
+		if s, ok := c.GetQuery(k); ok {
+			id, err := uuid.Parse(s)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"message": "Not a valid " + k + " value"})
+				return
+			}
+			db = db.Where(k+" = ?", id)
+		}
+	}
+	db = db.Session(&gorm.Session{})
+
+	var page, pagesize int
+	b, st, mess := a.checkPageParams(c, &page, &pagesize)
+	if !b {
+		c.JSON(st, gin.H{"message": mess})
+		return
+	}
+
+	var tc int64
+	if err = db.Model(&Review{}).Count(&tc).Error; err != nil {
+		a.Log.Info().Msgf("Error counting search results: [%s]", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Something went bang"})
+		return
+	}
+	if tc == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"total_reviews": tc})
+		return
+	}
+
+	var reviews []Review
+	err = db.Scopes(Paginate(page, pagesize)).
+		Select("reviews.*, ts_headline('english', review, "+searchQuery+", ?) AS snippet", q, headlineOptions).
+		Order(clause.OrderBy{Expression: clause.Expr{
+			SQL:  "ts_rank(" + searchVector + ", " + searchQuery + ") DESC, created DESC",
+			Vars: []interface{}{q},
+		}}).
+		Find(&reviews).Error
+	if err != nil {
+		a.Log.Info().Msgf("Error searching reviews: [%s]", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Something went bang"})
+		return
+	}
+	for i := range reviews {
+		reviews[i].Snippet = highlight(reviews[i].Snippet)
+	}
+	if len(reviews) > 0 {
+		if err = a.attachReplies(reviews); err != nil {
+			a.Log.Info().Msgf("Error fetching replies: [%s]", err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "Something went bang"})
+			return
+		}
+	}
+
+	totalPages := totalPagesFor(tc, pagesize)
+	if page > totalPages {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Page value is incorrect"})
+		return
+	}
+
+	urls, links := CreateURLS(c, page, totalPages)
+	resp := gin.H{"total_reviews": tc, "total_pages": totalPages, "current_page": page, "links": links, "reviews": reviews}
+	if len(urls) > 0 {
+		resp["urls"] = urls
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// ----------------------------------------------------------------------------
+
+// highlight escapes the snippet and wraps the matches in <mark> tags
+func highlight(s string) string {
+
+	s = html.EscapeString(s)
+	s = strings.ReplaceAll(s, markStart, "<mark>")
+	return strings.ReplaceAll(s, markStop, "</mark>")
+}
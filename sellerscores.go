@@ -0,0 +1,304 @@
+package main
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"strings"
+)
+
+// ----------------------------------------------------------------------------
+// s e l l e r   s c o r e   a g g r e g a t e s
+// ----------------------------------------------------------------------------
+
+// scoreDimensions are the names used in score_counts for each score column
+var scoreDimensions = []struct {
+	name   string
+	column string
+}{
+	{"overall", "overall"},
+	{"post_and_packaging", "pap_cost"},
+	{"communication", "comm"},
+	{"as_described", "as_desc"},
+}
+
+// addToSellerScores adds (sign = 1) or removes (sign = -1) a review's scores
+// from its seller's running totals and score counts, and the review from its
+// reviewer's written count. it must be called in the same transaction as the
+// change to the review so the totals can't drift
+func addToSellerScores(tx *gorm.DB, rv *Review, sign int) error {
+
+	ss := SellerScore{
+		Seller:      rv.Seller,
+		ReviewCount: int64(sign),
+		OverallSum:  int64(sign * rv.Overall),
+		PapCostSum:  int64(sign * rv.PapCost),
+		CommSum:     int64(sign * rv.Comm),
+		AsDescSum:   int64(sign * rv.AsDesc),
+	}
+
+	err := tx.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "seller"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"review_count": gorm.Expr("seller_scores.review_count + ?", ss.ReviewCount),
+			"overall_sum":  gorm.Expr("seller_scores.overall_sum + ?", ss.OverallSum),
+			"pap_cost_sum": gorm.Expr("seller_scores.pap_cost_sum + ?", ss.PapCostSum),
+			"comm_sum":     gorm.Expr("seller_scores.comm_sum + ?", ss.CommSum),
+			"as_desc_sum":  gorm.Expr("seller_scores.as_desc_sum + ?", ss.AsDescSum),
+			"updated":      gorm.Expr("NOW()"),
+		}),
+	}).Create(&ss).Error
+	if err != nil {
+		return err
+	}
+
+	err = tx.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "seller"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"written_count": gorm.Expr("seller_scores.written_count + ?", sign),
+			"updated":       gorm.Expr("NOW()"),
+		}),
+	}).Create(&SellerScore{Seller: rv.ReviewedBy, WrittenCount: int64(sign)}).Error
+	if err != nil {
+		return err
+	}
+
+	scores := []int{rv.Overall, rv.PapCost, rv.Comm, rv.AsDesc}
+	counts := make([]ScoreCount, len(scoreDimensions))
+	for i, d := range scoreDimensions {
+		counts[i] = ScoreCount{
+			Seller:    rv.Seller,
+			Dimension: d.name,
+			Score:     clampScore(scores[i]),
+			Total:     int64(sign),
+		}
+	}
+	return tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "seller"}, {Name: "dimension"}, {Name: "score"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"total": gorm.Expr("score_counts.total + excluded.total")}),
+	}).Create(&counts).Error
+}
+
+// ----------------------------------------------------------------------------
+
+// countsTowardsScores is true for reviews that are part of the seller totals
+func countsTowardsScores(rv *Review) bool {
+	return !rv.DeletedAt.Valid && !rv.Hidden
+}
+
+// updateSellerScores adds or removes a review from its seller's totals if
+// a change to it means it now does or doesn't count towards them
+func updateSellerScores(tx *gorm.DB, rv *Review, counted bool) error {
+
+	switch counts := countsTowardsScores(rv); {
+	case counted && !counts:
+		return addToSellerScores(tx, rv, -1)
+	case !counted && counts:
+		return addToSellerScores(tx, rv, 1)
+	}
+	return nil
+}
+
+// ----------------------------------------------------------------------------
+
+func (a *App) getSellerScore(sellerId uuid.UUID) (SellerScore, error) {
+
+	var ss SellerScore
+	err := a.DB.Where("seller = ?", sellerId).Limit(1).Find(&ss).Error
+	return ss, err
+}
+
+// ----------------------------------------------------------------------------
+
+func (ss SellerScore) averages() ReviewAverages {
+
+	if ss.ReviewCount <= 0 {
+		return ReviewAverages{}
+	}
+	n := float32(ss.ReviewCount)
+	return ReviewAverages{
+		ReviewCount:    int(ss.ReviewCount),
+		OverallAverage: float32(ss.OverallSum) / n,
+		PapCostAverage: float32(ss.PapCostSum) / n,
+		CommAverage:    float32(ss.CommSum) / n,
+		AsDescAverage:  float32(ss.AsDescSum) / n,
+	}
+}
+
+// ----------------------------------------------------------------------------
+
+// without takes another seller's totals off these ones so a seller's own
+// reviews aren't part of the prior they're shrunk towards
+func (ss SellerScore) without(other SellerScore) SellerScore {
+
+	ss.ReviewCount -= other.ReviewCount
+	ss.OverallSum -= other.OverallSum
+	ss.PapCostSum -= other.PapCostSum
+	ss.CommSum -= other.CommSum
+	ss.AsDescSum -= other.AsDescSum
+	return ss
+}
+
+// ----------------------------------------------------------------------------
+
+// liveSellerScores builds seller totals and written counts straight from
+// the reviews table
+func (a *App) liveSellerScores() *gorm.DB {
+
+	sellers := a.DB.Model(&Review{}).
+		Scopes(notHidden).
+		Select("seller, COUNT(*) as review_count, SUM(overall) as overall_sum, SUM(pap_cost) as pap_cost_sum, SUM(comm) as comm_sum, SUM(as_desc) as as_desc_sum").
+		Group("seller")
+	writers := a.DB.Model(&Review{}).
+		Scopes(notHidden).
+		Select("reviewed_by, COUNT(*) as written_count").
+		Group("reviewed_by")
+
+	return a.DB.Table("(?) as s FULL OUTER JOIN (?) as w ON s.seller = w.reviewed_by", sellers, writers).
+		Select("COALESCE(s.seller, w.reviewed_by) as seller, " +
+			"COALESCE(s.review_count, 0) as review_count, " +
+			"COALESCE(s.overall_sum, 0) as overall_sum, " +
+			"COALESCE(s.pap_cost_sum, 0) as pap_cost_sum, " +
+			"COALESCE(s.comm_sum, 0) as comm_sum, " +
+			"COALESCE(s.as_desc_sum, 0) as as_desc_sum, " +
+			"COALESCE(w.written_count, 0) as written_count")
+}
+
+// ----------------------------------------------------------------------------
+
+// liveScoreCounts builds the score counts straight from the reviews table.
+// scores off our scale are clamped the same as when they're added
+func (a *App) liveScoreCounts() *gorm.DB {
+
+	var values []string
+	for _, d := range scoreDimensions {
+		values = append(values, "('"+d.name+"', reviews."+d.column+")")
+	}
+	score := fmt.Sprintf("LEAST(GREATEST(d.score, %d), %d)", minScore, maxScore)
+	return a.DB.Model(&Review{}).
+		Scopes(notHidden).
+		Joins("CROSS JOIN LATERAL (VALUES " + strings.Join(values, ", ") + ") AS d(dimension, score)").
+		Select("seller, d.dimension, " + score + " as score, COUNT(*) as total").
+		Group("seller, d.dimension, " + score)
+}
+
+// ----------------------------------------------------------------------------
+
+// RebuildSellerScores throws away the seller_scores and score_counts table
+// contents and recalculates them from the reviews table
+func (a *App) RebuildSellerScores() error {
+
+	a.Log.Info().Msg("Rebuilding seller scores")
+
+	return a.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&SellerScore{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&ScoreCount{}).Error; err != nil {
+			return err
+		}
+		err := tx.Exec("INSERT INTO seller_scores (seller, review_count, overall_sum, pap_cost_sum, comm_sum, as_desc_sum, written_count, updated) "+
+			"SELECT seller, review_count, overall_sum, pap_cost_sum, comm_sum, as_desc_sum, written_count, NOW() FROM (?) as live",
+			a.liveSellerScores()).Error
+		if err != nil {
+			return err
+		}
+		return tx.Exec("INSERT INTO score_counts (seller, dimension, score, total) "+
+			"SELECT seller, dimension, score, total FROM (?) as live", a.liveScoreCounts()).Error
+	})
+}
+
+// ----------------------------------------------------------------------------
+
+// VerifySellerScores compares the seller_scores and score_counts tables with
+// totals calculated from the reviews table and returns any sellers where
+// they differ
+func (a *App) VerifySellerScores() ([]ScoreMismatch, error) {
+
+	var live []SellerScore
+	if err := a.liveSellerScores().Scan(&live).Error; err != nil {
+		return nil, err
+	}
+	var stored []SellerScore
+	if err := a.DB.Find(&stored).Error; err != nil {
+		return nil, err
+	}
+	var liveCounts []ScoreCount
+	if err := a.liveScoreCounts().Scan(&liveCounts).Error; err != nil {
+		return nil, err
+	}
+	var storedCounts []ScoreCount
+	if err := a.DB.Where("total <> 0").Find(&storedCounts).Error; err != nil {
+		return nil, err
+	}
+
+	// a seller's distribution differs if any of their counts do
+	counts := make(map[ScoreCount]int, len(liveCounts))
+	for _, sc := range liveCounts {
+		counts[sc]++
+	}
+	for _, sc := range storedCounts {
+		counts[sc]--
+	}
+	distDiffers := make(map[uuid.UUID]bool)
+	for sc, n := range counts {
+		if n != 0 {
+			distDiffers[sc.Seller] = true
+		}
+	}
+
+	storedBySeller := make(map[uuid.UUID]SellerScore, len(stored))
+	for _, ss := range stored {
+		storedBySeller[ss.Seller] = ss
+	}
+
+	var mismatches []ScoreMismatch
+	for _, l := range live {
+		s, ok := storedBySeller[l.Seller]
+		delete(storedBySeller, l.Seller)
+		differs := distDiffers[l.Seller]
+		delete(distDiffers, l.Seller)
+		if ok && s.sameTotals(l) && !differs {
+			continue
+		}
+		mismatches = append(mismatches, ScoreMismatch{
+			Seller:        l.Seller,
+			Live:          l.averages(),
+			Stored:        s.averages(),
+			LiveWritten:   l.WrittenCount,
+			StoredWritten: s.WrittenCount,
+			Distribution:  differs,
+		})
+	}
+	// anything left has totals but no live reviews
+	for seller, s := range storedBySeller {
+		differs := distDiffers[seller]
+		delete(distDiffers, seller)
+		if s.ReviewCount == 0 && s.WrittenCount == 0 && !differs {
+			continue
+		}
+		mismatches = append(mismatches, ScoreMismatch{
+			Seller:        seller,
+			Stored:        s.averages(),
+			StoredWritten: s.WrittenCount,
+			Distribution:  differs,
+		})
+	}
+	for seller := range distDiffers {
+		mismatches = append(mismatches, ScoreMismatch{Seller: seller, Distribution: true})
+	}
+
+	return mismatches, nil
+}
+
+// ----------------------------------------------------------------------------
+
+func (ss SellerScore) sameTotals(o SellerScore) bool {
+	return ss.ReviewCount == o.ReviewCount &&
+		ss.OverallSum == o.OverallSum &&
+		ss.PapCostSum == o.PapCostSum &&
+		ss.CommSum == o.CommSum &&
+		ss.AsDescSum == o.AsDescSum &&
+		ss.WrittenCount == o.WrittenCount
+}
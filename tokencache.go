@@ -0,0 +1,219 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"golang.org/x/sync/singleflight"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ----------------------------------------------------------------------------
+// t o k e n   c a c h e
+// ----------------------------------------------------------------------------
+
+// defaults used if the TOKEN_CACHE_* env vars aren't set
+const (
+	defaultTokenCacheSize       = 10000
+	defaultTokenCacheTTLSecs    = 30
+	defaultTokenCacheNegTTLSecs = 5
+)
+
+type tokenResult struct {
+	ok     bool
+	status int
+	mess   string
+	u      user
+}
+
+type tokenEntry struct {
+	key     string
+	res     tokenResult
+	expires time.Time
+}
+
+// tokenCache remembers who a token belongs to for a short while so that
+// the same token isn't sent to authy on every request. tokens authy turned
+// down are remembered for less time. the oldest entries are dropped once
+// the cache is full
+type tokenCache struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	maxSize int
+	ttl     time.Duration
+	negTTL  time.Duration
+	group   singleflight.Group
+
+	hits         atomic.Int64
+	negativeHits atomic.Int64
+	misses       atomic.Int64
+	coalesced    atomic.Int64
+	evictions    atomic.Int64
+}
+
+type TokenCacheStats struct {
+	Size         int     `json:"size"`
+	Hits         int64   `json:"hits"`
+	NegativeHits int64   `json:"negative_hits"`
+	Misses       int64   `json:"misses"`
+	Coalesced    int64   `json:"coalesced"`
+	Evictions    int64   `json:"evictions"`
+	HitRate      float32 `json:"hit_rate"`
+}
+
+// ----------------------------------------------------------------------------
+
+// InitialiseTokenCache sets up the token cache unless TOKEN_CACHE_SIZE is 0
+func (a *App) InitialiseTokenCache() {
+
+	size := envInt("TOKEN_CACHE_SIZE", defaultTokenCacheSize)
+	if size <= 0 {
+		a.Log.Info().Msg("Token cache is disabled")
+		a.TokenCache = nil
+		return
+	}
+	a.Log.Info().Msg("Initialising token cache")
+	a.TokenCache = newTokenCache(size,
+		time.Duration(envInt("TOKEN_CACHE_TTL_SECS", defaultTokenCacheTTLSecs))*time.Second,
+		time.Duration(envInt("TOKEN_CACHE_NEGATIVE_TTL_SECS", defaultTokenCacheNegTTLSecs))*time.Second)
+}
+
+// ----------------------------------------------------------------------------
+
+func newTokenCache(size int, ttl, negTTL time.Duration) *tokenCache {
+	return &tokenCache{
+		entries: make(map[string]*list.Element, size),
+		lru:     list.New(),
+		maxSize: size,
+		ttl:     ttl,
+		negTTL:  negTTL,
+	}
+}
+
+// ----------------------------------------------------------------------------
+
+// tokenKey hashes the token so we never hold on to the token itself
+func tokenKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// ----------------------------------------------------------------------------
+
+// verify returns the cached result for the token or calls check to get one.
+// concurrent requests with the same token share a single call to check
+func (tc *tokenCache) verify(token string, check func(string) (bool, int, string, user)) (bool, int, string, user) {
+
+	key := tokenKey(token)
+	if res, ok := tc.get(key); ok {
+		if res.ok {
+			tc.hits.Add(1)
+		} else {
+			tc.negativeHits.Add(1)
+		}
+		return res.ok, res.status, res.mess, res.u
+	}
+	tc.misses.Add(1)
+
+	v, _, shared := tc.group.Do(key, func() (interface{}, error) {
+		var res tokenResult
+		res.ok, res.status, res.mess, res.u = check(token)
+		// only definite answers are cached, not authy being unavailable
+		if res.ok {
+			tc.set(key, res, tc.ttl)
+		} else if res.status == http.StatusUnauthorized {
+			tc.set(key, res, tc.negTTL)
+		}
+		return res, nil
+	})
+	if shared {
+		tc.coalesced.Add(1)
+	}
+	res := v.(tokenResult)
+	return res.ok, res.status, res.mess, res.u
+}
+
+// ----------------------------------------------------------------------------
+
+func (tc *tokenCache) get(key string) (tokenResult, bool) {
+
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	el, ok := tc.entries[key]
+	if !ok {
+		return tokenResult{}, false
+	}
+	te := el.Value.(*tokenEntry)
+	if time.Now().After(te.expires) {
+		tc.lru.Remove(el)
+		delete(tc.entries, key)
+		return tokenResult{}, false
+	}
+	tc.lru.MoveToFront(el)
+	return te.res, true
+}
+
+// ----------------------------------------------------------------------------
+
+func (tc *tokenCache) set(key string, res tokenResult, ttl time.Duration) {
+
+	if ttl <= 0 {
+		return
+	}
+
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	if el, ok := tc.entries[key]; ok {
+		te := el.Value.(*tokenEntry)
+		te.res = res
+		te.expires = time.Now().Add(ttl)
+		tc.lru.MoveToFront(el)
+		return
+	}
+	for tc.lru.Len() >= tc.maxSize {
+		oldest := tc.lru.Back()
+		tc.lru.Remove(oldest)
+		delete(tc.entries, oldest.Value.(*tokenEntry).key)
+		tc.evictions.Add(1)
+	}
+	tc.entries[key] = tc.lru.PushFront(&tokenEntry{key: key, res: res, expires: time.Now().Add(ttl)})
+}
+
+// ----------------------------------------------------------------------------
+
+// purge empties the cache but leaves the counters alone
+func (tc *tokenCache) purge() {
+
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.entries = make(map[string]*list.Element, tc.maxSize)
+	tc.lru.Init()
+}
+
+// ----------------------------------------------------------------------------
+
+func (tc *tokenCache) stats() TokenCacheStats {
+
+	tc.mu.Lock()
+	size := tc.lru.Len()
+	tc.mu.Unlock()
+
+	st := TokenCacheStats{
+		Size:         size,
+		Hits:         tc.hits.Load(),
+		NegativeHits: tc.negativeHits.Load(),
+		Misses:       tc.misses.Load(),
+		Coalesced:    tc.coalesced.Load(),
+		Evictions:    tc.evictions.Load(),
+	}
+	if total := st.Hits + st.NegativeHits + st.Misses; total > 0 {
+		st.HitRate = roundFloat(float32(st.Hits+st.NegativeHits)/float32(total), 2)
+	}
+	return st
+}
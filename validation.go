@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"net/http"
+	"reflect"
+	"strings"
+	"unicode/utf8"
+)
+
+// review scores are on a scale of 0 to 5
+const (
+	minScore        = 0
+	maxScore        = 5
+	maxReviewLength = 2000
+)
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ----------------------------------------------------------------------------
+
+func (a *App) InitialiseValidators() {
+
+	a.Log.Info().Msg("Initialising validators")
+
+	v, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		a.Log.Fatal().Msg("Unable to get validator engine")
+		return
+	}
+
+	// report json field names rather than struct field names
+	v.RegisterTagNameFunc(func(fld reflect.StructField) string {
+		name := strings.SplitN(fld.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+
+	validations := map[string]validator.Func{
+		"score":      validScore,
+		"reviewtext": validReviewText,
+		"uuidne":     uuidNotEqualField,
+	}
+	for tag, fn := range validations {
+		if err := v.RegisterValidation(tag, fn); err != nil {
+			a.Log.Fatal().Msgf("Unable to register [%s] validator: [%s]", tag, err.Error())
+		}
+	}
+}
+
+// ----------------------------------------------------------------------------
+
+func validScore(fl validator.FieldLevel) bool {
+	s := fl.Field().Int()
+	return s >= minScore && s <= maxScore
+}
+
+// ----------------------------------------------------------------------------
+
+func validReviewText(fl validator.FieldLevel) bool {
+	return utf8.RuneCountInString(strings.TrimSpace(fl.Field().String())) <= maxReviewLength
+}
+
+// ----------------------------------------------------------------------------
+
+// uuidNotEqualField is needed as the built in nefield only compares the
+// length of arrays and a uuid is a [16]byte
+func uuidNotEqualField(fl validator.FieldLevel) bool {
+	other := fl.Parent().FieldByName(fl.Param())
+	if !other.IsValid() {
+		return true
+	}
+	return fl.Field().Interface() != other.Interface()
+}
+
+// ----------------------------------------------------------------------------
+
+// validationErrors turns binding errors into a list of field level errors
+// that can be returned to the client. it returns nil if the error isn't
+// something we can attribute to a particular field
+func validationErrors(err error) []FieldError {
+
+	var ve validator.ValidationErrors
+	if errors.As(err, &ve) {
+		fes := make([]FieldError, 0, len(ve))
+		for _, fe := range ve {
+			fes = append(fes, FieldError{Field: fe.Field(), Message: validationMessage(fe)})
+		}
+		return fes
+	}
+
+	var ute *json.UnmarshalTypeError
+	if errors.As(err, &ute) && ute.Field != "" {
+		return []FieldError{{Field: ute.Field, Message: fmt.Sprintf("%s must be of type %s", ute.Field, ute.Type.String())}}
+	}
+
+	return nil
+}
+
+// ----------------------------------------------------------------------------
+
+// badInput sends a 400 for a request body that couldn't be bound. errors is
+// only included when there are field level errors to report
+func badInput(c *gin.Context, err error) {
+
+	if fes := validationErrors(err); fes != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Input data is incorrect", "errors": fes})
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"message": "Input data is incorrect"})
+}
+
+// ----------------------------------------------------------------------------
+
+func validationMessage(fe validator.FieldError) string {
+
+	switch fe.Tag() {
+	case "required":
+		return fe.Field() + " is required"
+	case "score":
+		return fmt.Sprintf("%s must be between %d and %d", fe.Field(), minScore, maxScore)
+	case "reviewtext":
+		return fmt.Sprintf("%s must be no more than %d characters", fe.Field(), maxReviewLength)
+	case "max":
+		return fmt.Sprintf("%s must be no more than %s characters", fe.Field(), fe.Param())
+	case "uuidne":
+		return "you cannot review yourself"
+	}
+	return fe.Field() + " is not valid"
+}